@@ -0,0 +1,99 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// agentAddress returns the address of the local Vault Agent listener, from
+// VAULT_AGENT_ADDR or agent_address, "" when no agent is used
+func agentAddress() string {
+	if addr := os.Getenv("VAULT_AGENT_ADDR"); addr != "" {
+		return addr
+	}
+	return cfg.AgentAddress
+}
+
+// agentInUse reports whether requests go through Vault Agent, which
+// authenticates them with its auto-auth token so guttu doesn't login itself
+func agentInUse() bool {
+	return agentAddress() != ""
+}
+
+// vaultAddresses returns the addresses requests are tried on in order: the
+// agent when one is used, else vault_address followed by vault_addresses
+func vaultAddresses() []string {
+	if addr := agentAddress(); addr != "" {
+		if strings.HasPrefix(addr, "unix://") {
+			// the host is ignored, the unix socket dialer connects
+			return []string{"http://vault-agent"}
+		}
+		return []string{strings.TrimRight(addr, "/")}
+	}
+	var addrs []string
+	for _, addr := range append([]string{cfg.VaultAddress}, cfg.VaultAddresses...) {
+		if addr != "" {
+			addrs = append(addrs, strings.TrimRight(addr, "/"))
+		}
+	}
+	return addrs
+}
+
+// vaultHTTPClient returns the client for Vault requests, dialing the agent
+// socket for unix:// agent addresses
+func vaultHTTPClient() *http.Client {
+	client := &http.Client{Timeout: vaultTimeout()}
+	addr := agentAddress()
+	if strings.HasPrefix(addr, "unix://") {
+		client.Transport = agentTransport(strings.TrimPrefix(addr, "unix://"))
+	}
+	return client
+}
+
+// agentTransports holds a transport per agent socket, so that connections to
+// the agent are kept alive and reused between requests
+var (
+	agentTransports   = map[string]*http.Transport{}
+	agentTransportsMu sync.Mutex
+)
+
+// agentTransport returns the transport dialing the agent socket
+func agentTransport(socket string) *http.Transport {
+	agentTransportsMu.Lock()
+	defer agentTransportsMu.Unlock()
+	if transport, ok := agentTransports[socket]; ok {
+		return transport
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socket)
+		},
+	}
+	agentTransports[socket] = transport
+	return transport
+}
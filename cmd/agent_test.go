@@ -0,0 +1,68 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestAgentSocketConnectionReused(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("unix sockets unavailable:", err)
+	}
+	var connections int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data":{"display_name":"agent"}}`))
+	}))
+	server.Listener = listener
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&connections, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	savedCfg := cfg
+	defer func() { cfg = savedCfg }()
+	cfg = GuttuConfigStruct{AuditLog: "off", VaultMaxAttempts: 1}
+	t.Setenv("VAULT_AGENT_ADDR", "unix://"+socket)
+
+	for i := 0; i < 3; i++ {
+		lookup, err := lookupToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lookup.Data.DisplayName != "agent" {
+			t.Errorf("display name = %q", lookup.Data.DisplayName)
+		}
+	}
+	if n := atomic.LoadInt32(&connections); n != 1 {
+		t.Errorf("%d connections to the agent, want 1", n)
+	}
+}
@@ -0,0 +1,233 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var knownSSHAlgorithmKeys = []string{"ciphers", "kex", "macs", "host_key_algorithms"}
+
+// the algorithms the SSH client built into guttu implements, its defaults first
+var (
+	defaultSSHCiphers   = []string{"aes128-gcm@openssh.com", "chacha20-poly1305@openssh.com", "aes128-ctr", "aes192-ctr", "aes256-ctr"}
+	supportedSSHCiphers = []string{"aes128-gcm@openssh.com", "chacha20-poly1305@openssh.com", "aes128-ctr", "aes192-ctr", "aes256-ctr", "arcfour256", "arcfour128", "arcfour", "aes128-cbc", "3des-cbc"}
+	supportedSSHKEX     = []string{"curve25519-sha256@libssh.org", "ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521", "diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1"}
+	supportedSSHMACs    = []string{"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96"}
+	supportedSSHHostKey = []string{
+		ssh.CertAlgoRSAv01, ssh.CertAlgoDSAv01, ssh.CertAlgoECDSA256v01, ssh.CertAlgoECDSA384v01, ssh.CertAlgoECDSA521v01, ssh.CertAlgoED25519v01,
+		ssh.KeyAlgoECDSA256, ssh.KeyAlgoECDSA384, ssh.KeyAlgoECDSA521, ssh.KeyAlgoRSA, ssh.KeyAlgoDSA, ssh.KeyAlgoED25519,
+	}
+)
+
+// sshNegotiation holds the algorithms guttu and a server agree on
+type sshNegotiation struct {
+	KEX     string
+	HostKey string
+	Cipher  string
+	MAC     string
+}
+
+// serverSSHAlgorithms returns the algorithms allowed with a server, those it
+// sets overriding the top level ssh settings. Empty lists mean the defaults.
+func serverSSHAlgorithms(s GuttuServerStruct) SSHAlgorithmsStruct {
+	var a SSHAlgorithmsStruct
+	if cfg.SSH != nil {
+		a = *cfg.SSH
+	}
+	if s.SSH != nil {
+		if len(s.SSH.Ciphers) > 0 {
+			a.Ciphers = s.SSH.Ciphers
+		}
+		if len(s.SSH.KEX) > 0 {
+			a.KEX = s.SSH.KEX
+		}
+		if len(s.SSH.MACs) > 0 {
+			a.MACs = s.SSH.MACs
+		}
+		if len(s.SSH.HostKeyAlgorithms) > 0 {
+			a.HostKeyAlgorithms = s.SSH.HostKeyAlgorithms
+		}
+	}
+	return a
+}
+
+// applySSHAlgorithms restricts the client config to the algorithms allowed with the server
+func applySSHAlgorithms(config *ssh.ClientConfig, a SSHAlgorithmsStruct) {
+	config.Ciphers = a.Ciphers
+	config.KeyExchanges = a.KEX
+	config.MACs = a.MACs
+	config.HostKeyAlgorithms = a.HostKeyAlgorithms
+}
+
+// sshAlgorithmOptions returns the ssh -o options allowing the algorithms, for the sshpass backend
+func sshAlgorithmOptions(a SSHAlgorithmsStruct) []string {
+	var options []string
+	add := func(name string, list []string) {
+		if len(list) > 0 {
+			options = append(options, "-o", name+"="+strings.Join(list, ","))
+		}
+	}
+	add("Ciphers", a.Ciphers)
+	add("KexAlgorithms", a.KEX)
+	add("MACs", a.MACs)
+	add("HostKeyAlgorithms", a.HostKeyAlgorithms)
+	return options
+}
+
+// serverUsesNativeSSH reports whether sessions on a server use the native
+// backend going by the config alone, flags of guttu ssh aside
+func serverUsesNativeSSH(s GuttuServerStruct) bool {
+	if cfg.SSHBackend == "native" || cfg.Multiplex || s.Multiplex || s.Record {
+		return true
+	}
+	for _, tag := range s.Tags {
+		if stringInSlice(tag, cfg.RecordTags) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsupportedSSHAlgorithms returns the algorithms the SSH client built into guttu doesn't implement
+func unsupportedSSHAlgorithms(a *SSHAlgorithmsStruct) []string {
+	if a == nil {
+		return nil
+	}
+	var unsupported []string
+	check := func(list, supported []string) {
+		for _, name := range list {
+			if !stringInSlice(name, supported) {
+				unsupported = append(unsupported, name)
+			}
+		}
+	}
+	check(a.Ciphers, supportedSSHCiphers)
+	check(a.KEX, supportedSSHKEX)
+	check(a.MACs, supportedSSHMACs)
+	check(a.HostKeyAlgorithms, supportedSSHHostKey)
+	return unsupported
+}
+
+// probeSSHAlgorithms reads the algorithms a server offers from its first key
+// exchange message, without logging in, and picks those guttu would use with it
+func probeSSHAlgorithms(s GuttuServerStruct) (sshNegotiation, error) {
+	var n sshNegotiation
+	addr := net.JoinHostPort(s.IP, strconv.Itoa(serverPort(s)))
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		return n, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := io.WriteString(conn, "SSH-2.0-guttu\r\n"); err != nil {
+		return n, err
+	}
+	r := bufio.NewReader(conn)
+	// servers may send other lines before their version
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return n, err
+		}
+		if strings.HasPrefix(line, "SSH-") {
+			break
+		}
+	}
+	offered, err := readKexInit(r)
+	if err != nil {
+		return n, err
+	}
+
+	a := serverSSHAlgorithms(s)
+	pick := func(what string, ours, defaults, theirs []string) (string, error) {
+		if len(ours) == 0 {
+			ours = defaults
+		}
+		for _, name := range ours {
+			if stringInSlice(name, theirs) {
+				return name, nil
+			}
+		}
+		return "", fmt.Errorf("no common %s, the server offers %s", what, strings.Join(theirs, ","))
+	}
+	if n.KEX, err = pick("key exchange", a.KEX, supportedSSHKEX, offered[0]); err != nil {
+		return n, err
+	}
+	if n.HostKey, err = pick("host key algorithm", a.HostKeyAlgorithms, supportedSSHHostKey, offered[1]); err != nil {
+		return n, err
+	}
+	if n.Cipher, err = pick("cipher", a.Ciphers, defaultSSHCiphers, offered[2]); err != nil {
+		return n, err
+	}
+	if n.MAC, err = pick("MAC", a.MACs, supportedSSHMACs, offered[4]); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// readKexInit reads the SSH_MSG_KEXINIT packet a server sends after its
+// version and returns its name-lists: key exchange, host key, ciphers and
+// MACs, client to server first, then compression and languages
+func readKexInit(r io.Reader) ([][]string, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	length, padding := binary.BigEndian.Uint32(header[:4]), int(header[4])
+	if length < 1 || length > 35000 || padding >= int(length) {
+		return nil, errors.New("invalid packet from the server")
+	}
+	packet := make([]byte, length-1)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return nil, err
+	}
+	payload := packet[:len(packet)-padding]
+	// message number 20, then a 16 byte cookie
+	if len(payload) < 17 || payload[0] != 20 {
+		return nil, errors.New("the server didn't start a key exchange")
+	}
+	payload = payload[17:]
+	lists := make([][]string, 10)
+	for i := range lists {
+		if len(payload) < 4 {
+			return nil, errors.New("truncated key exchange message")
+		}
+		size := binary.BigEndian.Uint32(payload)
+		if uint32(len(payload)-4) < size {
+			return nil, errors.New("truncated key exchange message")
+		}
+		lists[i] = strings.Split(string(payload[4:4+size]), ",")
+		payload = payload[4+size:]
+	}
+	return lists, nil
+}
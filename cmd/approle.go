@@ -0,0 +1,131 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// VaultAppRoleLoginRequest struct for the payload of an AppRole login
+type VaultAppRoleLoginRequest struct {
+	RoleID   string `json:"role_id"`
+	SecretID Secret `json:"secret_id"`
+}
+
+// VaultUnwrapSecretIDResponse struct for a response wrapped secret_id once unwrapped
+type VaultUnwrapSecretIDResponse struct {
+	Data struct {
+		SecretID Secret `json:"secret_id"`
+	} `json:"data"`
+}
+
+// appRoleLogin logs in with the role_id and secret_id read from files or the
+// environment, unwrapping the secret_id first when it was response wrapped
+func appRoleLogin(mount string) {
+	roleID := readCredential("role_id", cfg.RoleIDFile, "GUTTU_ROLE_ID")
+	secretID := readCredential("secret_id", cfg.SecretIDFile, "GUTTU_SECRET_ID")
+	defer wipeBytes(secretID)
+
+	if cfg.SecretIDWrapped {
+		// the file holds a single use wrapping token instead of the secret_id
+		unwrapped := VaultUnwrapSecretIDResponse{}
+		if err := vaultRequestWithToken(string(secretID), "POST", "sys/wrapping/unwrap", nil, &unwrapped); err != nil {
+			logger.Error("Unable to unwrap the secret_id")
+			exitOnVaultError(err)
+		}
+		wipeBytes(secretID)
+		secretID = unwrapped.Data.SecretID
+	}
+
+	logger.Info("Logging into Vault...")
+	loginResponse := VaultAuthLoginResponse{}
+	if err := vaultRequest("POST", "auth/"+mount+"/login", VaultAppRoleLoginRequest{RoleID: string(roleID), SecretID: secretID}, &loginResponse); err != nil {
+		exitOnVaultError(err)
+	}
+	finishLogin(loginResponse)
+}
+
+// readCredential reads a credential from the configured file, else from the environment variable
+func readCredential(name, file, env string) []byte {
+	if file != "" {
+		path, err := homedir.Expand(file)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			logger.Fatalf("Unable to read the %s: %s", name, err)
+		}
+		trimmed := bytes.TrimSpace(content)
+		value := append([]byte{}, trimmed...)
+		wipeBytes(content)
+		return value
+	}
+	if value := os.Getenv(env); value != "" {
+		return []byte(value)
+	}
+	logger.Fatalf("Error: no %s, set %s_file or %s", name, name, env)
+	return nil
+}
+
+// tokenFromFile reads the token written by Vault Agent to its sink file
+func tokenFromFile() {
+	token := readCredential("token", cfg.TokenFile, "VAULT_TOKEN")
+	vaultUserToken = string(token)
+	wipeBytes(token)
+	if _, err := lookupToken(); err != nil {
+		vaultUserToken = ""
+		exitOnVaultError(err)
+	}
+	// the token belongs to Vault Agent (or whoever set VAULT_TOKEN), never revoke it
+	vaultTokenStored = true
+	vaultTokenSource = "VAULT_TOKEN"
+	if cfg.TokenFile != "" {
+		vaultTokenSource = "token_file"
+	}
+	audit(AuditEvent{Event: auditTokenReuse, VaultAddress: vaultAddress(), TokenSource: vaultTokenSource})
+}
+
+// isInteractive reports whether guttu can prompt, which it never does when stdin is not a terminal
+func isInteractive() bool {
+	return terminal.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// requireInteractive exits instead of prompting when stdin is not a terminal, as in CI jobs
+func requireInteractive(what string) {
+	if !isInteractive() {
+		logger.Fatalf("Error: %s needs a terminal and stdin is not one", what)
+	}
+}
+
+// requireInteractiveLogin exits like a refused login instead of asking for
+// credentials when stdin is not a terminal
+func requireInteractiveLogin(what string) {
+	if !isInteractive() {
+		logger.Errorf("Error: %s needs a terminal and stdin is not one", what)
+		os.Exit(exitAuthFailed)
+	}
+}
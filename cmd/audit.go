@@ -0,0 +1,285 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+// audit events
+const (
+	auditLogin        = "login"
+	auditTokenReuse   = "token_reuse"
+	auditCredsIssued  = "creds_issued"
+	auditConnect      = "connect"
+	auditDisconnect   = "disconnect"
+	auditForward      = "forward_opened"
+	auditLeaseRevoked = "lease_revoked"
+	auditTokenRevoked = "token_revoked"
+	auditError        = "error"
+)
+
+// AuditEvent struct for a single line of the audit log. It never holds a
+// token, password or OTP.
+type AuditEvent struct {
+	Time          time.Time `json:"time" yaml:"time"`
+	Event         string    `json:"event" yaml:"event"`
+	User          string    `json:"user" yaml:"user"`
+	VaultAddress  string    `json:"vault_address,omitempty" yaml:"vault_address,omitempty"`
+	AuthMethod    string    `json:"auth_method,omitempty" yaml:"auth_method,omitempty"`
+	TokenSource   string    `json:"token_source,omitempty" yaml:"token_source,omitempty"`
+	ServerName    string    `json:"server_name,omitempty" yaml:"server_name,omitempty"`
+	IP            string    `json:"ip,omitempty" yaml:"ip,omitempty"`
+	LoginUsername string    `json:"login_username,omitempty" yaml:"login_username,omitempty"`
+	VaultRole     string    `json:"vault_role,omitempty" yaml:"vault_role,omitempty"`
+	LeaseID       string    `json:"lease_id,omitempty" yaml:"lease_id,omitempty"`
+	Backend       string    `json:"backend,omitempty" yaml:"backend,omitempty"`
+	Forward       string    `json:"forward,omitempty" yaml:"forward,omitempty"`
+	Command       string    `json:"command,omitempty" yaml:"command,omitempty"`
+	ExitStatus    *int      `json:"exit_status,omitempty" yaml:"exit_status,omitempty"`
+	Duration      float64   `json:"duration_seconds,omitempty" yaml:"duration_seconds,omitempty"`
+	Error         string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+var auditServer string
+var auditEvent string
+var auditSince string
+var auditUntil string
+var auditLimit int
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Search the audit log of guttu activity",
+	Long: `List the events of the audit log, oldest first: Vault logins, token reuse,
+OTPs issued with their lease, and sessions opened and closed with their exit status.
+
+--since and --until take a time (2006-01-02 or 2006-01-02T15:04:05Z07:00) or a
+duration back from now (24h).`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		since, err := parseAuditTime(auditSince)
+		if err != nil {
+			logger.Fatal("Invalid --since:", err)
+		}
+		until, err := parseAuditTime(auditUntil)
+		if err != nil {
+			logger.Fatal("Invalid --until:", err)
+		}
+		events, err := readAuditLog()
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		matched := []AuditEvent{}
+		for _, e := range events {
+			if (auditServer != "" && e.ServerName != auditServer) || (auditEvent != "" && e.Event != auditEvent) ||
+				(!since.IsZero() && e.Time.Before(since)) || (!until.IsZero() && e.Time.After(until)) {
+				continue
+			}
+			matched = append(matched, e)
+		}
+		if auditLimit > 0 && len(matched) > auditLimit {
+			matched = matched[len(matched)-auditLimit:]
+		}
+
+		var rows [][]string
+		for _, e := range matched {
+			rows = append(rows, []string{e.Time.Local().Format("2006-01-02 15:04:05"), e.Event, e.User, e.ServerName, auditDetails(e)})
+		}
+		printResult(matched, []string{"Time", "Event", "User", "Server Name", "Details"}, rows)
+	},
+}
+
+// auditDetails summarizes the fields of an event that depend on its kind
+func auditDetails(e AuditEvent) string {
+	var details []string
+	add := func(name, value string) {
+		if value != "" {
+			details = append(details, name+"="+value)
+		}
+	}
+	add("method", e.AuthMethod)
+	add("token", e.TokenSource)
+	add("role", e.VaultRole)
+	add("lease", e.LeaseID)
+	add("backend", e.Backend)
+	add("forward", e.Forward)
+	add("command", e.Command)
+	if e.ExitStatus != nil {
+		add("exit", strconv.Itoa(*e.ExitStatus))
+	}
+	if e.Duration > 0 {
+		add("duration", time.Duration(e.Duration*float64(time.Second)).Round(time.Second).String())
+	}
+	add("error", e.Error)
+	return strings.Join(details, " ")
+}
+
+// parseAuditTime parses a time of the audit command flags, "" being no limit
+func parseAuditTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", value, time.Local)
+}
+
+// auditLogPath returns the audit log file, ~/.guttu_audit unless configured,
+// "" when the events go to syslog or nowhere
+func auditLogPath() (string, error) {
+	switch {
+	case cfg.AuditLog == "off", isSyslogAudit():
+		return "", nil
+	case cfg.AuditLog != "":
+		return homedir.Expand(cfg.AuditLog)
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".guttu_audit"), nil
+}
+
+// isSyslogAudit reports whether audit events go to syslog, set with
+// audit_log: syslog, or syslog:/path/to/socket for another socket than the default
+func isSyslogAudit() bool {
+	return cfg.AuditLog == "syslog" || strings.HasPrefix(cfg.AuditLog, "syslog:")
+}
+
+// audit appends an event to the audit log, a failure only warns
+func audit(e AuditEvent) {
+	if cfg.AuditLog == "off" {
+		return
+	}
+	e.Time = time.Now()
+	e.User = localUsername()
+	e.Error = redactSecrets(e.Error)
+	line, err := json.Marshal(e)
+	if err != nil {
+		logger.Warn("Unable to write the audit log:", err)
+		return
+	}
+
+	var w io.WriteCloser
+	if isSyslogAudit() {
+		w, err = openSyslog(strings.TrimPrefix(strings.TrimPrefix(cfg.AuditLog, "syslog"), ":"))
+	} else {
+		var path string
+		if path, err = auditLogPath(); err == nil {
+			w, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		}
+	}
+	if err != nil {
+		logger.Warn("Unable to write the audit log:", err)
+		return
+	}
+	defer w.Close()
+	if _, err := w.Write(append(line, '\n')); err != nil {
+		logger.Warn("Unable to write the audit log:", err)
+	}
+}
+
+// auditServerEvent returns an event about the selected server
+func auditServerEvent(event string) AuditEvent {
+	return AuditEvent{
+		Event:         event,
+		ServerName:    selectedServer.ServerName,
+		IP:            selectedServer.IP,
+		LoginUsername: selectedServer.LoginUsername,
+		VaultRole:     selectedServer.VaultRole,
+		LeaseID:       vaultSSHOTPLeaseID,
+	}
+}
+
+// auditAgentForwarded records that the SSH agent is forwarded to the selected server
+func auditAgentForwarded() {
+	e := auditServerEvent(auditForward)
+	e.Forward = "agent"
+	audit(e)
+}
+
+// redactSecrets hides the Vault token and OTP in use should they show up in a message
+func redactSecrets(s string) string {
+	if vaultUserToken != "" {
+		s = strings.Replace(s, vaultUserToken, "[redacted]", -1)
+	}
+	if len(vaultSSHOTPKey) > 0 {
+		s = strings.Replace(s, string(vaultSSHOTPKey), "[redacted]", -1)
+	}
+	return s
+}
+
+// readAuditLog returns all the events of the audit log file, oldest first
+func readAuditLog() ([]AuditEvent, error) {
+	if isSyslogAudit() {
+		return nil, fmt.Errorf("the audit log goes to syslog, search it there")
+	}
+	path, err := auditLogPath()
+	if err != nil || path == "" {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e AuditEvent
+		// skip lines we can't make sense of instead of refusing to work
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			events = append(events, e)
+		}
+	}
+	return events, scanner.Err()
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+
+	auditCmd.Flags().StringVar(&auditServer, "server", "", "only show events about this server")
+	auditCmd.Flags().StringVar(&auditEvent, "event", "", "only show events of this kind, such as creds_issued")
+	auditCmd.Flags().StringVar(&auditSince, "since", "", "only show events from this time on")
+	auditCmd.Flags().StringVar(&auditUntil, "until", "", "only show events up to this time")
+	auditCmd.Flags().IntVarP(&auditLimit, "limit", "n", 0, "only show the last n events")
+}
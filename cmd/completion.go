@@ -0,0 +1,389 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// completionCmd represents the completion command
+var completionCmd = &cobra.Command{
+	Use:   "completion bash|zsh|fish",
+	Short: "Generate the shell completion script",
+	Long: `Generate the completion script of your shell. Server names and tags are
+completed from your config files every time you hit tab.
+
+  bash: source <(guttu completion bash)
+  zsh:  guttu completion zsh > "${fpath[1]}/_guttu"
+  fish: guttu completion fish > ~/.config/fish/completions/guttu.fish`,
+	ValidArgs: []string{"bash", "zsh", "fish"},
+	Args:      cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		switch args[0] {
+		case "bash":
+			// the flags are defined by the init of other files, which may run after this one
+			for _, c := range []*cobra.Command{serversAddCmd, serversEditCmd} {
+				c.MarkFlagCustom("tag", "__guttu_tags")
+			}
+			auditCmd.MarkFlagCustom("server", "__guttu_servers")
+			rootCmd.MarkPersistentFlagFilename("config", "yaml", "yml")
+			rootCmd.BashCompletionFunction = bashCompletionFunction()
+			err = rootCmd.GenBashCompletion(os.Stdout)
+		case "zsh":
+			err = genZshCompletion(os.Stdout)
+		case "fish":
+			err = genFishCompletion(os.Stdout)
+		default:
+			logger.Fatalf("Unknown shell %q, use bash, zsh or fish", args[0])
+		}
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+// completeCmd is called by the completion scripts for the values they can't know in advance
+var completeCmd = &cobra.Command{
+	Use:       "__guttu_complete servers|tags",
+	Hidden:    true,
+	ValidArgs: []string{"servers", "tags"},
+	Args:      cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, value := range completionValues(args[0]) {
+			fmt.Println(value)
+		}
+	},
+}
+
+// serverArgCommands take a server name as argument, serverFlags a server name as value
+func serverArgCommands() []*cobra.Command {
+	return []*cobra.Command{sshCmd, serversShowCmd, serversEditCmd, serversRemoveCmd, muxStopCmd, tunnelCmd}
+}
+
+// completionValues returns the server names or the tags of the loaded config
+func completionValues(kind string) []string {
+	var values []string
+	switch kind {
+	case "servers":
+		for _, s := range pickerOrder() {
+			values = append(values, s.ServerName)
+		}
+	case "tags":
+		seen := map[string]bool{}
+		for _, s := range cfg.Servers {
+			for _, tag := range s.Tags {
+				if !seen[tag] {
+					seen[tag] = true
+					values = append(values, tag)
+				}
+			}
+		}
+		sort.Strings(values)
+	}
+	return values
+}
+
+// bashCompletionFunction returns the bash functions completing server names and tags
+func bashCompletionFunction() string {
+	var buf bytes.Buffer
+	buf.WriteString(`__guttu_complete()
+{
+    local values
+    values=$(guttu __guttu_complete "$1" 2>/dev/null)
+    COMPREPLY=( $(compgen -W "${values}" -- "$cur") )
+}
+
+__guttu_servers()
+{
+    __guttu_complete servers
+}
+
+__guttu_tags()
+{
+    __guttu_complete tags
+}
+
+__custom_func()
+{
+    case ${last_command} in
+`)
+	var names []string
+	for _, c := range serverArgCommands() {
+		names = append(names, strings.Replace(c.CommandPath(), " ", "_", -1))
+	}
+	fmt.Fprintf(&buf, "        %s)\n", strings.Join(names, " | "))
+	buf.WriteString(`            __guttu_servers
+            return
+            ;;
+        *)
+            ;;
+    esac
+}
+`)
+	return buf.String()
+}
+
+// genZshCompletion writes a zsh completion script for the commands and their flags
+func genZshCompletion(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(`#compdef guttu
+
+_guttu_servers() {
+  local -a servers
+  servers=(${(f)"$(guttu __guttu_complete servers 2>/dev/null)"})
+  _describe 'server' servers
+}
+
+_guttu_tags() {
+  local -a tags
+  tags=(${(f)"$(guttu __guttu_complete tags 2>/dev/null)"})
+  _describe 'tag' tags
+}
+
+_guttu() {
+  local curcontext="$curcontext" state line
+  typeset -A opt_args
+  _arguments -C \
+`)
+	writeZshFlags(&buf, rootCmd, "    ")
+	buf.WriteString(`    '1: :->command' \
+    '*:: :->args'
+
+  case $state in
+    command)
+      local -a commands
+      commands=(
+`)
+	writeZshCommands(&buf, rootCmd, "        ")
+	buf.WriteString(`      )
+      _describe 'command' commands
+      ;;
+    args)
+      case $line[1] in
+`)
+	for _, c := range rootCmd.Commands() {
+		if !c.IsAvailableCommand() {
+			continue
+		}
+		fmt.Fprintf(&buf, "        %s)\n", c.Name())
+		if c.HasAvailableSubCommands() {
+			buf.WriteString("          _arguments -C \\\n")
+			writeZshFlags(&buf, c, "            ")
+			buf.WriteString("            '1: :->sub' '*:: :->subargs'\n")
+			buf.WriteString("          case $state in\n            sub)\n              local -a subcommands\n              subcommands=(\n")
+			writeZshCommands(&buf, c, "                ")
+			buf.WriteString("              )\n              _describe 'command' subcommands\n              ;;\n            subargs)\n              case $line[1] in\n")
+			for _, sub := range c.Commands() {
+				if !sub.IsAvailableCommand() {
+					continue
+				}
+				fmt.Fprintf(&buf, "                %s)\n                  _arguments \\\n", sub.Name())
+				writeZshFlags(&buf, sub, "                    ")
+				fmt.Fprintf(&buf, "                    '*: :%s'\n                  ;;\n", zshArgAction(sub))
+			}
+			buf.WriteString("              esac\n              ;;\n          esac\n          ;;\n")
+			continue
+		}
+		buf.WriteString("          _arguments \\\n")
+		writeZshFlags(&buf, c, "            ")
+		fmt.Fprintf(&buf, "            '*: :%s'\n          ;;\n", zshArgAction(c))
+	}
+	buf.WriteString(`      esac
+      ;;
+  esac
+}
+
+_guttu "$@"
+`)
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+// zshArgAction returns how zsh completes the arguments of a command
+func zshArgAction(c *cobra.Command) string {
+	for _, s := range serverArgCommands() {
+		if s == c {
+			return "_guttu_servers"
+		}
+	}
+	if len(c.ValidArgs) > 0 {
+		return "(" + strings.Join(c.ValidArgs, " ") + ")"
+	}
+	if c == replayCmd {
+		return "_files"
+	}
+	return " "
+}
+
+func writeZshCommands(buf *bytes.Buffer, parent *cobra.Command, indent string) {
+	for _, c := range parent.Commands() {
+		if c.IsAvailableCommand() {
+			fmt.Fprintf(buf, "%s%s\n", indent, zshQuote(c.Name()+":"+c.Short))
+		}
+	}
+}
+
+func writeZshFlags(buf *bytes.Buffer, c *cobra.Command, indent string) {
+	c.LocalFlags().VisitAll(func(f *pflag.Flag) {
+		if f.Hidden {
+			return
+		}
+		action := ""
+		if f.Value.Type() != "bool" && f.Value.Type() != "count" {
+			action = ": :" + zshFlagAction(f.Name)
+		}
+		desc := strings.Replace(strings.Replace(f.Usage, "]", `\]`, -1), "'", `'\''`, -1)
+		if f.Shorthand != "" {
+			fmt.Fprintf(buf, "%s'(-%s --%s)'{-%s,--%s}'[%s]%s' \\\n", indent, f.Shorthand, f.Name, f.Shorthand, f.Name, desc, action)
+			return
+		}
+		fmt.Fprintf(buf, "%s'--%s[%s]%s' \\\n", indent, f.Name, desc, action)
+	})
+}
+
+// zshFlagAction returns how zsh completes the value of a flag
+func zshFlagAction(name string) string {
+	switch name {
+	case "tag":
+		return "_guttu_tags"
+	case "server":
+		return "_guttu_servers"
+	case "output":
+		return "(" + strings.Join(outputFormats, " ") + ")"
+	case "config", "file":
+		return "_files"
+	}
+	return " "
+}
+
+func zshQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// genFishCompletion writes a fish completion script for the commands and their flags
+func genFishCompletion(w io.Writer) error {
+	var buf bytes.Buffer
+	buf.WriteString(`# fish completion for guttu
+
+function __guttu_using_command
+    set -l cmd (commandline -opc)
+    set -e cmd[1]
+    set -l words
+    for word in $cmd
+        switch $word
+            case '-*'
+            case '*'
+                set words $words $word
+        end
+    end
+    test "$words" = "$argv"
+end
+
+complete -c guttu -f
+`)
+	writeFishFlags(&buf, rootCmd, "")
+	for _, c := range rootCmd.Commands() {
+		if !c.IsAvailableCommand() {
+			continue
+		}
+		fmt.Fprintf(&buf, "complete -c guttu -n '__guttu_using_command' -a %s -d %s\n", c.Name(), fishQuote(c.Short))
+		writeFishFlags(&buf, c, c.Name())
+		writeFishArgs(&buf, c, c.Name())
+		for _, sub := range c.Commands() {
+			if !sub.IsAvailableCommand() {
+				continue
+			}
+			path := c.Name() + " " + sub.Name()
+			fmt.Fprintf(&buf, "complete -c guttu -n '__guttu_using_command %s' -a %s -d %s\n", c.Name(), sub.Name(), fishQuote(sub.Short))
+			writeFishFlags(&buf, sub, path)
+			writeFishArgs(&buf, sub, path)
+		}
+	}
+	_, err := buf.WriteTo(w)
+	return err
+}
+
+func writeFishArgs(buf *bytes.Buffer, c *cobra.Command, path string) {
+	for _, s := range serverArgCommands() {
+		if s == c {
+			fmt.Fprintf(buf, "complete -c guttu -n '__guttu_using_command %s' -a '(guttu __guttu_complete servers 2>/dev/null)'\n", path)
+			return
+		}
+	}
+	if len(c.ValidArgs) > 0 {
+		fmt.Fprintf(buf, "complete -c guttu -n '__guttu_using_command %s' -a %s\n", path, fishQuote(strings.Join(c.ValidArgs, " ")))
+	}
+	if c == replayCmd {
+		fmt.Fprintf(buf, "complete -c guttu -n '__guttu_using_command %s' -F\n", path)
+	}
+}
+
+func writeFishFlags(buf *bytes.Buffer, c *cobra.Command, path string) {
+	condition := ""
+	if path != "" {
+		condition = fmt.Sprintf(" -n '__guttu_using_command %s'", path)
+	}
+	flags := c.LocalFlags()
+	if c == rootCmd {
+		flags = c.PersistentFlags()
+	}
+	flags.VisitAll(func(f *pflag.Flag) {
+		if f.Hidden {
+			return
+		}
+		line := fmt.Sprintf("complete -c guttu%s -l %s", condition, f.Name)
+		if f.Shorthand != "" {
+			line += " -s " + f.Shorthand
+		}
+		if f.Value.Type() != "bool" && f.Value.Type() != "count" {
+			switch f.Name {
+			case "tag":
+				line += " -x -a '(guttu __guttu_complete tags 2>/dev/null)'"
+			case "server":
+				line += " -x -a '(guttu __guttu_complete servers 2>/dev/null)'"
+			case "output":
+				line += " -x -a " + fishQuote(strings.Join(outputFormats, " "))
+			case "config", "file":
+				line += " -r -F"
+			default:
+				line += " -r"
+			}
+		}
+		fmt.Fprintf(buf, "%s -d %s\n", line, fishQuote(f.Usage))
+	})
+}
+
+func fishQuote(s string) string {
+	return "'" + strings.Replace(strings.Replace(s, `\`, `\\`, -1), "'", `\'`, -1) + "'"
+}
+
+func init() {
+	rootCmd.AddCommand(completionCmd, completeCmd)
+}
@@ -0,0 +1,455 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// knownConfigKeys lists the top level keys guttu understands
+var knownConfigKeys = []string{"vault_address", "vault_addresses", "vault_timeout", "vault_max_attempts", "agent_address", "history_file", "sshpass_secret", "revoke_lease", "keep_token", "auth_method", "auth_mount", "auth_role", "jwt_file", "role_id_file", "secret_id_file", "secret_id_wrapped", "token_file", "oidc_callback_port", "token_store", "audit_log", "ssh_backend", "record_tags", "recordings_dir", "recordings_keep", "multiplex", "multiplex_idle", "keepalive_interval", "keepalive_count_max", "ssh", "include", "servers"}
+
+// knownServerKeys lists the keys guttu understands for a server entry
+var knownServerKeys = []string{"ip", "server_name", "login_username", "vault_role", "port", "proxy_jump", "favourite", "tags", "record", "forward_agent", "multiplex", "ssh", "send_env", "set_env", "remote_command"}
+
+// ConfigProblem struct for a single problem found in the config file
+type ConfigProblem struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (p ConfigProblem) String() string {
+	switch {
+	case p.File != "" && p.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", p.File, p.Line, p.Message)
+	case p.File != "":
+		return fmt.Sprintf("%s: %s", p.File, p.Message)
+	default:
+		return p.Message
+	}
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect your config files",
+	Long:  `Commands for inspecting and checking the guttu config files.`,
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the config file for mistakes",
+	Long: `Check the config file for unknown keys, missing required fields, malformed
+Vault addresses and IPs and duplicate server names.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configFileUsed() == "" {
+			logger.Fatal("No config file found")
+		}
+		problems := validateConfig()
+		for _, p := range problems {
+			fmt.Println(p)
+		}
+		if len(problems) > 0 {
+			os.Exit(1)
+		}
+		fmt.Println("Config is valid:", strings.Join(cfgFiles, ", "))
+	},
+}
+
+var showOrigin bool
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the merged config",
+	Long: `Show the config guttu uses after merging /etc/guttu/config.yaml,
+$XDG_CONFIG_HOME/guttu/config.yaml, ~/.guttu.yaml, ./.guttu.yaml, the --config
+file and their includes. With --origin every value is listed with the file it
+came from.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		settings := viper.AllSettings()
+		if !showOrigin {
+			out, err := yaml.Marshal(settings)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			fmt.Print(string(out))
+			return
+		}
+
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Key", "Value", "Origin"})
+		table.SetAutoWrapText(false)
+		for _, key := range sortedKeys(settings) {
+			if key == "servers" {
+				servers, _ := settings[key].([]interface{})
+				for i, raw := range servers {
+					entry := toStringMap(raw)
+					name := fmt.Sprint(entry["server_name"])
+					appendOrigins(table, fmt.Sprintf("servers[%s]", name), fmt.Sprintf("servers.%d", i), entry)
+				}
+				continue
+			}
+			appendOrigins(table, key, key, settings[key])
+		}
+		table.Render()
+	},
+}
+
+// appendOrigins adds a row for every leaf value with the file it was read from
+func appendOrigins(table *tablewriter.Table, label, key string, value interface{}) {
+	if m := toStringMap(value); m != nil {
+		for _, k := range sortedKeys(m) {
+			appendOrigins(table, label+"."+k, key+"."+k, m[k])
+		}
+		return
+	}
+	origin := "default"
+	if env := strings.ToUpper(key); !strings.Contains(key, ".") && os.Getenv(env) != "" {
+		origin = "$" + env
+	} else if o, ok := configOriginOf(key); ok {
+		origin = o.File
+	}
+	table.Append([]string{label, fmt.Sprint(value), origin})
+}
+
+// checkConfig validates the config file before a command runs, unless the
+// command is one of those used to inspect or repair the config file
+func checkConfig(cmd *cobra.Command) {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c == configCmd || c == serversCmd || c == lsCmd || c == doctorCmd || c == replayCmd || c == muxCmd || c.Name() == "help" || c.Name() == "completion" || c == completeCmd {
+			return
+		}
+	}
+	problems := validateConfig()
+	if len(problems) == 0 {
+		return
+	}
+	for _, p := range problems {
+		fmt.Fprintln(os.Stderr, p)
+	}
+	fmt.Fprintln(os.Stderr, "Fix the config file, `guttu config validate` checks it again")
+	os.Exit(1)
+}
+
+// validateConfig checks the loaded config files and returns every problem found
+func validateConfig() []ConfigProblem {
+	problems := append([]ConfigProblem{}, cfgLoadProblems...)
+	if len(cfgFiles) == 0 {
+		return problems
+	}
+
+	lines := map[string]configLines{}
+	report := func(key string, format string, a ...interface{}) {
+		p := ConfigProblem{File: configFileUsed(), Message: fmt.Sprintf(format, a...)}
+		if origin, ok := configOriginOf(key); ok {
+			if _, ok := lines[origin.File]; !ok {
+				lines[origin.File] = locateConfigLines(origin.File)
+			}
+			p.File = origin.File
+			p.Line = lines[origin.File].line(origin, key)
+		}
+		problems = append(problems, p)
+	}
+
+	if cfgUnmarshalErr != nil {
+		report("", "%s", cfgUnmarshalErr)
+	}
+
+	settings := viper.AllSettings()
+	for _, key := range sortedKeys(settings) {
+		if !stringInSlice(key, knownConfigKeys) {
+			report(key, "unknown key %q", key)
+		}
+	}
+
+	if cfg.AgentAddress != "" {
+		if u, err := url.Parse(cfg.AgentAddress); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "unix") {
+			report("agent_address", "agent_address %q is not a valid http(s) or unix URL", cfg.AgentAddress)
+		}
+	}
+	if cfg.VaultAddress == "" && len(cfg.VaultAddresses) == 0 {
+		if !agentInUse() {
+			report("", "vault_address is required")
+		}
+	} else if cfg.VaultAddress != "" && !validVaultAddress(cfg.VaultAddress) {
+		report("vault_address", "vault_address %q is not a valid http(s) URL", cfg.VaultAddress)
+	}
+	for _, addr := range cfg.VaultAddresses {
+		if !validVaultAddress(addr) {
+			report("vault_addresses", "vault_addresses entry %q is not a valid http(s) URL", addr)
+		}
+	}
+	if cfg.VaultTimeout < 0 {
+		report("vault_timeout", "vault_timeout must not be negative")
+	}
+	if cfg.VaultMaxAttempts < 0 {
+		report("vault_max_attempts", "vault_max_attempts must not be negative")
+	}
+
+	if cfg.SSHPassSecret != "" && cfg.SSHPassSecret != "fd" && cfg.SSHPassSecret != "env" {
+		report("sshpass_secret", "sshpass_secret %q must be fd or env", cfg.SSHPassSecret)
+	}
+	if cfg.SSHBackend != "" && cfg.SSHBackend != "sshpass" && cfg.SSHBackend != "native" {
+		report("ssh_backend", "ssh_backend %q must be sshpass or native", cfg.SSHBackend)
+	}
+	if cfg.RecordingsKeep < 0 {
+		report("recordings_keep", "recordings_keep must not be negative")
+	}
+	if cfg.MultiplexIdle < 0 {
+		report("multiplex_idle", "multiplex_idle must not be negative")
+	}
+	if cfg.KeepaliveInterval < 0 {
+		report("keepalive_interval", "keepalive_interval must not be negative")
+	}
+	if cfg.KeepaliveCountMax < 0 {
+		report("keepalive_count_max", "keepalive_count_max must not be negative")
+	}
+	for _, k := range sortedKeys(toStringMap(settings["ssh"])) {
+		if !stringInSlice(k, knownSSHAlgorithmKeys) {
+			report("ssh", "unknown key %q in ssh", k)
+		}
+	}
+
+	rawServers, _ := settings["servers"].([]interface{})
+	seen := map[string]int{}
+	for i, raw := range rawServers {
+		key := func(k string) string { return fmt.Sprintf("servers.%d.%s", i, k) }
+		entry := toStringMap(raw)
+		for _, k := range sortedKeys(entry) {
+			if !stringInSlice(k, knownServerKeys) {
+				report(key(k), "unknown key %q in server #%d", k, i+1)
+			}
+		}
+		for _, k := range sortedKeys(toStringMap(entry["ssh"])) {
+			if !stringInSlice(k, knownSSHAlgorithmKeys) {
+				report(key("ssh"), "unknown key %q in the ssh settings of server #%d", k, i+1)
+			}
+		}
+		if i >= len(cfg.Servers) {
+			continue
+		}
+
+		s := cfg.Servers[i]
+		name := s.ServerName
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+			report(key(""), "server #%d has no server_name", i+1)
+		} else if first, dup := seen[name]; dup {
+			report(key("server_name"), "server name %q is already used by server #%d", name, first+1)
+		} else {
+			seen[name] = i
+		}
+		if s.IP == "" {
+			report(key(""), "server %s has no ip", name)
+		} else if net.ParseIP(s.IP) == nil {
+			report(key("ip"), "server %s has an invalid ip %q", name, s.IP)
+		}
+		if s.LoginUsername == "" {
+			report(key(""), "server %s has no login_username", name)
+		}
+		if s.VaultRole == "" {
+			report(key(""), "server %s has no vault_role", name)
+		}
+		if s.Port < 0 || s.Port > 65535 {
+			report(key("port"), "server %s has an invalid port %d", name, s.Port)
+		}
+		for _, kv := range s.SetEnv {
+			if !validEnvAssignment(kv) {
+				report(key("set_env"), "server %s has a set_env entry %q not of the NAME=value form", name, kv)
+			}
+		}
+		// sshpass leaves the algorithms to OpenSSH, only the native client is limited
+		if serverUsesNativeSSH(s) {
+			algorithms := serverSSHAlgorithms(s)
+			at := "ssh"
+			if s.SSH != nil {
+				at = key("ssh")
+			}
+			for _, algorithm := range unsupportedSSHAlgorithms(&algorithms) {
+				report(at, "server %s uses the native backend, which doesn't support the ssh algorithm %q", name, algorithm)
+			}
+		}
+	}
+	return problems
+}
+
+// configLines struct for the line numbers of the keys of a YAML config file
+type configLines struct {
+	top     map[string]int
+	servers []map[string]int // "" holds the line the server entry starts on
+}
+
+// line returns the line of a key read from the file, 0 when unknown
+func (l configLines) line(origin configOrigin, key string) int {
+	parts := strings.Split(key, ".")
+	if origin.Server == -1 {
+		return l.top[parts[0]]
+	}
+	if origin.Server >= len(l.servers) {
+		return 0
+	}
+	if n, ok := l.servers[origin.Server][parts[len(parts)-1]]; ok {
+		return n
+	}
+	return l.servers[origin.Server][""]
+}
+
+var yamlKeyLine = regexp.MustCompile(`^(\s*)(- +)?([A-Za-z0-9_.-]+)\s*:`)
+
+// validVaultAddress reports whether addr is an http(s) URL with a host
+func validVaultAddress(addr string) bool {
+	u, err := url.Parse(addr)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// locateConfigLines finds on which line each top level key and each server
+// key is written. It only understands block style YAML, other formats get no
+// line numbers.
+func locateConfigLines(file string) configLines {
+	lines := configLines{top: map[string]int{}}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return lines
+	}
+	text := strings.Split(string(data), "\n")
+	layout := scanConfigLayout(text)
+	for key, i := range layout.top {
+		lines.top[key] = i + 1
+	}
+	for n, start := range layout.items {
+		end := layout.serversEnd
+		if n+1 < len(layout.items) {
+			end = layout.items[n+1]
+		}
+		server := map[string]int{"": start + 1}
+		for i := start; i < end; i++ {
+			if m := yamlKeyLine.FindStringSubmatch(text[i]); m != nil {
+				if key := strings.ToLower(m[3]); server[key] == 0 {
+					server[key] = i + 1
+				}
+			}
+		}
+		lines.servers = append(lines.servers, server)
+	}
+	return lines
+}
+
+// configLayout struct for where the top level keys and the server entries of
+// a block style YAML config file are, as indexes of its lines
+type configLayout struct {
+	top        map[string]int // lower cased top level keys
+	serversEnd int            // the line after the last one of the servers list, -1 without servers
+	items      []int          // the lines of the "-" starting each server entry
+	itemIndent string         // what precedes the "-" of the server entries
+}
+
+// scanConfigLayout finds the top level keys and the server entries of a
+// config file. A top level value runs until the next top level key, so flow
+// style lists spanning lines belong to their key but have no entries found.
+func scanConfigLayout(lines []string) configLayout {
+	layout := configLayout{top: map[string]int{}, serversEnd: -1}
+	inServers := false
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r\n")
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if indent == "" && !strings.HasPrefix(trimmed, "-") {
+			if m := yamlKeyLine.FindStringSubmatch(line); m != nil {
+				key := strings.ToLower(m[3])
+				if _, ok := layout.top[key]; !ok {
+					layout.top[key] = i
+				}
+				inServers = key == "servers"
+				if inServers {
+					layout.serversEnd = i + 1
+				}
+				continue
+			}
+		}
+		if !inServers {
+			continue
+		}
+		layout.serversEnd = i + 1
+		if strings.HasPrefix(trimmed, "-") && (len(layout.items) == 0 || indent == layout.itemIndent) {
+			layout.itemIndent = indent
+			layout.items = append(layout.items, i)
+		}
+	}
+	return layout
+}
+
+// toStringMap turns a decoded map, whatever the config format, into a map with string keys
+func toStringMap(raw interface{}) map[string]interface{} {
+	switch m := raw.(type) {
+	case map[string]interface{}:
+		return m
+	case map[interface{}]interface{}:
+		out := map[string]interface{}{}
+		for k, v := range m {
+			out[strings.ToLower(fmt.Sprint(k))] = v
+		}
+		return out
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func stringInSlice(s string, list []string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd, configShowCmd)
+
+	configShowCmd.Flags().BoolVar(&showOrigin, "origin", false, "show the file each value came from")
+}
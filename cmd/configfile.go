@@ -0,0 +1,283 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// serversFile is a config file opened to edit its servers. The text of the
+// file is kept, so that comments, key order and every server left unchanged
+// are written back as they were.
+type serversFile struct {
+	path   string
+	head   []string // lines up to the servers key
+	tail   []string // lines after the servers list
+	indent string   // indentation of the "-" of each server
+	items  []*serverItem
+}
+
+// serverItem is a server of the file, with what was read for it
+type serverItem struct {
+	server GuttuServerStruct
+	read   *GuttuServerStruct
+	entry  yaml.MapSlice
+	lines  []string
+}
+
+var serversBlockKey = regexp.MustCompile(`^servers\s*:\s*(#.*)?$`)
+
+// readServersFile reads the servers of a YAML config file, a missing file has none
+func readServersFile(path string) (*serversFile, error) {
+	f := &serversFile{path: path}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var doc struct {
+		Servers []yaml.MapSlice `yaml:"servers"`
+	}
+	var typed struct {
+		Servers []GuttuServerStruct `yaml:"servers"`
+	}
+	var top yaml.MapSlice
+	for _, out := range []interface{}{&doc, &typed, &top} {
+		if err := yaml.Unmarshal(data, out); err != nil {
+			return nil, err
+		}
+	}
+
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if n := len(lines); n > 0 && !strings.HasSuffix(lines[n-1], "\n") {
+		lines[n-1] += "\n"
+	}
+	layout := scanConfigLayout(lines)
+	key, found := layout.top["servers"]
+	if !found {
+		for _, item := range top {
+			if item.Key == "servers" {
+				return nil, fmt.Errorf("%s: unable to find where servers is written, edit it by hand", path)
+			}
+		}
+		f.head = append(lines, "servers:\n")
+	} else {
+		f.head = append([]string{}, lines[:key+1]...)
+		end := layout.serversEnd
+		f.tail = lines[end:]
+		if len(layout.items) == len(doc.Servers) && serversBlockKey.MatchString(strings.TrimRight(lines[key], "\r\n")) {
+			f.indent = layout.itemIndent
+			f.splitItems(lines[key+1:end], layout.items, key+1)
+		} else {
+			// servers written inline, such as "servers: []" or a flow style list, are rewritten as a block
+			f.head[key] = "servers:\n"
+		}
+	}
+	if len(f.items) != len(doc.Servers) {
+		for range doc.Servers {
+			f.items = append(f.items, &serverItem{})
+		}
+	}
+	for i, item := range f.items {
+		read := typed.Servers[i]
+		item.server, item.read, item.entry = read, &read, doc.Servers[i]
+	}
+	return f, nil
+}
+
+// splitItems splits the lines of the servers list into one item per server,
+// items holding where each server starts counting from offset. The comments
+// above a server go with it.
+func (f *serversFile) splitItems(lines []string, items []int, offset int) {
+	if len(items) == 0 {
+		f.head = append(f.head, lines...)
+		return
+	}
+	starts := make([]int, len(items))
+	for i, item := range items {
+		start := item - offset
+		for start > 0 && (i == 0 || start > starts[i-1]+1) && commentLine(lines[start-1]) {
+			start--
+		}
+		starts[i] = start
+	}
+	f.head = append(f.head, lines[:starts[0]]...)
+	for i, start := range starts {
+		end := len(lines)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		f.items = append(f.items, &serverItem{lines: lines[start:end]})
+	}
+}
+
+// commentLine reports whether a line is blank or only a comment
+func commentLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed == "" || strings.HasPrefix(trimmed, "#")
+}
+
+// servers returns the servers of the file
+func (f *serversFile) servers() []GuttuServerStruct {
+	servers := make([]GuttuServerStruct, len(f.items))
+	for i, item := range f.items {
+		servers[i] = item.server
+	}
+	return servers
+}
+
+// index returns the position of the named server, -1 when there is none
+func (f *serversFile) index(name string) int {
+	for i, item := range f.items {
+		if item.server.ServerName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (f *serversFile) add(s GuttuServerStruct) {
+	f.items = append(f.items, &serverItem{server: s})
+}
+
+func (f *serversFile) remove(i int) {
+	f.items = append(f.items[:i], f.items[i+1:]...)
+}
+
+// bytes returns the file with its servers written back
+func (f *serversFile) bytes() ([]byte, error) {
+	var b strings.Builder
+	for _, line := range f.head {
+		b.WriteString(line)
+	}
+	for _, item := range f.items {
+		if item.read != nil && item.lines != nil && reflect.DeepEqual(item.server, *item.read) {
+			for _, line := range item.lines {
+				b.WriteString(line)
+			}
+			continue
+		}
+		entry, err := mergeServerEntry(item.entry, item.server)
+		if err != nil {
+			return nil, err
+		}
+		out, err := yaml.Marshal([]yaml.MapSlice{entry})
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.SplitAfter(strings.TrimSuffix(string(out), "\n"), "\n") {
+			b.WriteString(f.indent + line)
+		}
+		b.WriteString("\n")
+	}
+	for _, line := range f.tail {
+		b.WriteString(line)
+	}
+	return []byte(b.String()), nil
+}
+
+// mergeServerEntry returns the entry of the server as written to the file, keeping
+// the order of the keys it was read with and the keys guttu doesn't know about
+func mergeServerEntry(read yaml.MapSlice, s GuttuServerStruct) (yaml.MapSlice, error) {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var fields yaml.MapSlice
+	if err := yaml.Unmarshal(out, &fields); err != nil {
+		return nil, err
+	}
+	values := map[interface{}]interface{}{}
+	for _, field := range fields {
+		values[field.Key] = field.Value
+	}
+	var entry yaml.MapSlice
+	for _, item := range read {
+		if value, ok := values[item.Key]; ok {
+			entry = append(entry, yaml.MapItem{Key: item.Key, Value: value})
+			delete(values, item.Key)
+		} else if key, ok := item.Key.(string); !ok || !stringInSlice(key, knownServerKeys) {
+			entry = append(entry, item)
+		}
+	}
+	for _, field := range fields {
+		if _, ok := values[field.Key]; ok {
+			entry = append(entry, field)
+		}
+	}
+	return entry, nil
+}
+
+// save writes the file back, an existing file keeps its permissions
+func (f *serversFile) save() error {
+	data, err := f.bytes()
+	if err != nil {
+		return err
+	}
+	path := f.path
+	mode := os.FileMode(0600)
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	return writeFileAtomic(path, data, mode)
+}
+
+// writeFileAtomic writes a file through a temporary file renamed over it, so
+// that it is never left half written and ends up with the given permissions
+// even when it existed before
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("unable to replace %s: %s", path, err)
+	}
+	return nil
+}
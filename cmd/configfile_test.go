@@ -0,0 +1,306 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testServersFile = `# guttu config
+vault_address: https://vault:8200 # primary
+
+servers:
+  # staging
+  - server_name: staging
+    vault_role: staging-role
+    ip: 10.0.0.1
+    login_username: ubuntu
+    owner: ops # not read by guttu
+  # production
+  - server_name: prod
+    ip: 10.0.0.2
+    login_username: ubuntu
+    vault_role: prod-role
+
+# kept after the servers
+keep_token: true
+`
+
+// editServersFile writes contents to a config file, applies edit to it and returns what was saved
+func editServersFile(t *testing.T, contents string, edit func(f *serversFile)) string {
+	path := filepath.Join(t.TempDir(), "guttu.yaml")
+	if contents != "" {
+		if err := ioutil.WriteFile(path, []byte(contents), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := readServersFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(f)
+	if err := f.save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(saved)
+}
+
+func TestServersFileUnchanged(t *testing.T) {
+	saved := editServersFile(t, testServersFile, func(f *serversFile) {})
+	if saved != testServersFile {
+		t.Errorf("file changed:\n%s", saved)
+	}
+}
+
+func TestServersFileAdd(t *testing.T) {
+	saved := editServersFile(t, testServersFile, func(f *serversFile) {
+		f.add(GuttuServerStruct{ServerName: "db", IP: "10.0.0.3", LoginUsername: "admin", VaultRole: "db-role", Port: 2222})
+	})
+	want := testServersFile[:len(testServersFile)-len("\n# kept after the servers\nkeep_token: true\n")] + `  - ip: 10.0.0.3
+    server_name: db
+    login_username: admin
+    vault_role: db-role
+    port: 2222
+
+# kept after the servers
+keep_token: true
+`
+	if saved != want {
+		t.Errorf("saved:\n%s\nwant:\n%s", saved, want)
+	}
+}
+
+func TestServersFileEdit(t *testing.T) {
+	saved := editServersFile(t, testServersFile, func(f *serversFile) {
+		s := &f.items[f.index("staging")].server
+		s.ServerName = "stage"
+		s.Favourite = true
+	})
+	want := `# guttu config
+vault_address: https://vault:8200 # primary
+
+servers:
+  - server_name: stage
+    vault_role: staging-role
+    ip: 10.0.0.1
+    login_username: ubuntu
+    owner: ops
+    favourite: true
+  # production
+  - server_name: prod
+    ip: 10.0.0.2
+    login_username: ubuntu
+    vault_role: prod-role
+
+# kept after the servers
+keep_token: true
+`
+	if saved != want {
+		t.Errorf("saved:\n%s\nwant:\n%s", saved, want)
+	}
+}
+
+func TestServersFileRemove(t *testing.T) {
+	saved := editServersFile(t, testServersFile, func(f *serversFile) {
+		f.remove(f.index("staging"))
+	})
+	want := `# guttu config
+vault_address: https://vault:8200 # primary
+
+servers:
+  # production
+  - server_name: prod
+    ip: 10.0.0.2
+    login_username: ubuntu
+    vault_role: prod-role
+
+# kept after the servers
+keep_token: true
+`
+	if saved != want {
+		t.Errorf("saved:\n%s\nwant:\n%s", saved, want)
+	}
+}
+
+func TestServersFileNew(t *testing.T) {
+	saved := editServersFile(t, "", func(f *serversFile) {
+		f.add(GuttuServerStruct{ServerName: "db", IP: "10.0.0.3", LoginUsername: "admin", VaultRole: "db-role"})
+	})
+	want := `servers:
+- ip: 10.0.0.3
+  server_name: db
+  login_username: admin
+  vault_role: db-role
+`
+	if saved != want {
+		t.Errorf("saved:\n%s\nwant:\n%s", saved, want)
+	}
+}
+
+func TestServersFileInline(t *testing.T) {
+	saved := editServersFile(t, "servers: []\nkeep_token: true\n", func(f *serversFile) {
+		f.add(GuttuServerStruct{ServerName: "db", IP: "10.0.0.3", LoginUsername: "admin", VaultRole: "db-role"})
+	})
+	want := `servers:
+- ip: 10.0.0.3
+  server_name: db
+  login_username: admin
+  vault_role: db-role
+keep_token: true
+`
+	if saved != want {
+		t.Errorf("saved:\n%s\nwant:\n%s", saved, want)
+	}
+}
+
+func TestServersFileKeepsMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guttu.yaml")
+	if err := ioutil.WriteFile(path, []byte(testServersFile), 0640); err != nil {
+		t.Fatal(err)
+	}
+	f, err := readServersFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.remove(0)
+	if err := f.save(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %o, want 640", info.Mode().Perm())
+	}
+	if files, _ := ioutil.ReadDir(filepath.Dir(path)); len(files) != 1 {
+		t.Errorf("%d files left in the directory, want 1", len(files))
+	}
+}
+
+func TestServersFileFlowStyle(t *testing.T) {
+	tests := map[string]string{
+		"one line": `servers: [{server_name: web, ip: 10.0.0.1, login_username: ubuntu, vault_role: web-role}]
+keep_token: true
+`,
+		"several lines": `servers: [
+  {server_name: web, ip: 10.0.0.1, login_username: ubuntu, vault_role: web-role},
+]
+keep_token: true
+`,
+	}
+	want := `servers:
+- server_name: web
+  ip: 10.0.0.1
+  login_username: ubuntu
+  vault_role: web-role
+- ip: 10.0.0.3
+  server_name: db
+  login_username: admin
+  vault_role: db-role
+keep_token: true
+`
+	for name, contents := range tests {
+		t.Run(name, func(t *testing.T) {
+			saved := editServersFile(t, contents, func(f *serversFile) {
+				f.add(GuttuServerStruct{ServerName: "db", IP: "10.0.0.3", LoginUsername: "admin", VaultRole: "db-role"})
+			})
+			if saved != want {
+				t.Errorf("saved:\n%s\nwant:\n%s", saved, want)
+			}
+		})
+	}
+}
+
+func TestServersFileQuotedValues(t *testing.T) {
+	contents := `servers:
+- server_name: "prod web"
+  ip: '10.0.0.1'
+  login_username: "ubuntu" # quoted
+  vault_role: "role: prod"
+- server_name: 'it''s'
+  ip: "10.0.0.2"
+  login_username: ubuntu
+  vault_role: "r"
+`
+	saved := editServersFile(t, contents, func(f *serversFile) {
+		f.items[f.index("it's")].server.Port = 2222
+	})
+	want := `servers:
+- server_name: "prod web"
+  ip: '10.0.0.1'
+  login_username: "ubuntu" # quoted
+  vault_role: "role: prod"
+- server_name: it's
+  ip: 10.0.0.2
+  login_username: ubuntu
+  vault_role: r
+  port: 2222
+`
+	if saved != want {
+		t.Errorf("saved:\n%s\nwant:\n%s", saved, want)
+	}
+
+	path := filepath.Join(t.TempDir(), "guttu.yaml")
+	if err := ioutil.WriteFile(path, []byte(saved), 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err := readServersFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := f.items[0].server; s.ServerName != "prod web" || s.VaultRole != "role: prod" {
+		t.Errorf("first server read back as %+v", s)
+	}
+}
+
+func TestServersFileEmptyList(t *testing.T) {
+	saved := editServersFile(t, "servers:\nkeep_token: true\n", func(f *serversFile) {
+		f.add(GuttuServerStruct{ServerName: "db", IP: "10.0.0.3", LoginUsername: "admin", VaultRole: "db-role"})
+	})
+	want := `servers:
+- ip: 10.0.0.3
+  server_name: db
+  login_username: admin
+  vault_role: db-role
+keep_token: true
+`
+	if saved != want {
+		t.Errorf("saved:\n%s\nwant:\n%s", saved, want)
+	}
+}
+
+func TestServersFileQuotedKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "guttu.yaml")
+	if err := ioutil.WriteFile(path, []byte("\"servers\": []\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readServersFile(path); err == nil {
+		t.Error("a servers key the scanner can't find was accepted, it would be written twice")
+	}
+}
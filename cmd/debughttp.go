@@ -0,0 +1,123 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+var debugHTTP bool
+
+// redactedHeaders and redactedFields hold the headers, JSON fields and query
+// parameters that carry credentials and are never dumped
+var redactedHeaders = []string{"X-Vault-Token", "Authorization", "Cookie", "Set-Cookie"}
+var redactedFields = []string{
+	"id", "password", "token", "client_token", "accessor", "key", "secret_id", "role_id", "jwt",
+	"passcode", "mfa_payload", "code", "state", "client_nonce", "wrapping_token", "id_token",
+}
+
+const redacted = "[redacted]"
+
+// dumpHTTPRequest logs a Vault request for --debug-http
+func dumpHTTPRequest(req *http.Request, body []byte) {
+	lines := []string{fmt.Sprintf("> %s %s", req.Method, redactURL(req.URL))}
+	lines = append(lines, dumpHeaders(">", req.Header)...)
+	if len(body) > 0 {
+		lines = append(lines, "> "+redactJSON(body))
+	}
+	logger.write(levelDebug, strings.Join(lines, "\n"))
+}
+
+// dumpHTTPResponse logs a Vault response for --debug-http
+func dumpHTTPResponse(resp *http.Response, body []byte) {
+	lines := []string{"< " + resp.Status}
+	lines = append(lines, dumpHeaders("<", resp.Header)...)
+	if len(body) > 0 {
+		lines = append(lines, "< "+redactJSON(body))
+	}
+	logger.write(levelDebug, strings.Join(lines, "\n"))
+}
+
+func dumpHeaders(prefix string, header http.Header) []string {
+	var lines []string
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := strings.Join(header[name], ", ")
+		for _, secret := range redactedHeaders {
+			if strings.EqualFold(name, secret) {
+				value = redacted
+			}
+		}
+		lines = append(lines, fmt.Sprintf("%s %s: %s", prefix, name, value))
+	}
+	return lines
+}
+
+// redactURL returns the URL with the values of credential query parameters, such as the OIDC code, hidden
+func redactURL(u *url.URL) string {
+	query := u.Query()
+	for name := range query {
+		if stringInSlice(name, redactedFields) {
+			query.Set(name, redacted)
+		}
+	}
+	redactedURL := *u
+	redactedURL.RawQuery = query.Encode()
+	return redactedURL.String()
+}
+
+// redactJSON returns a JSON body with the values of credential fields hidden,
+// at any depth. Other bodies are only described by their size.
+func redactJSON(body []byte) string {
+	var decoded interface{}
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		return fmt.Sprintf("[%d bytes]", len(body))
+	}
+	out, _ := json.Marshal(redactValue(decoded))
+	return string(out)
+}
+
+func redactValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for k, field := range value {
+			if stringInSlice(k, redactedFields) && field != nil && field != "" {
+				value[k] = redacted
+			} else {
+				value[k] = redactValue(field)
+			}
+		}
+	case []interface{}:
+		for i := range value {
+			value[i] = redactValue(value[i])
+		}
+	}
+	return v
+}
@@ -22,30 +22,180 @@ package cmd
 
 import (
 	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/spf13/cobra"
 )
 
+var doctorSSHFlag bool
+
+// VaultHealthResponse struct for the response of sys/health
+type VaultHealthResponse struct {
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Standby     bool   `json:"standby"`
+	Version     string `json:"version"`
+}
+
+// DoctorCheck struct for one line of the doctor report
+type DoctorCheck struct {
+	Name   string `json:"name" yaml:"name"`
+	OK     bool   `json:"ok" yaml:"ok"`
+	Detail string `json:"detail" yaml:"detail"`
+}
+
 // doctorCmd represents the doctor command
 var doctorCmd = &cobra.Command{
 	Use:   "doctor",
 	Short: "Show information about the installed tooling.",
-	Long:  `Command for verifying needed things for guttu to work`,
+	Long: `Command for verifying needed things for guttu to work: the config
+files, the tools guttu runs, Vault or Vault Agent and the stored token.
+With --ssh it also reports the algorithms the native client negotiates with
+every server. The sshpass backend leaves them to OpenSSH, which may pick others.`,
+	Args: cobra.NoArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		fmt.Println("doctor called")
+		checks := []DoctorCheck{checkConfigFiles()}
+		checks = append(checks, checkTool("ssh"), checkTool("sshpass"))
+		checks = append(checks, checkVault()...)
+		checks = append(checks, checkStoredToken())
+		if doctorSSHFlag {
+			checks = append(checks, checkSSHAlgorithms()...)
+		}
+
+		healthy := true
+		var rows [][]string
+		for _, c := range checks {
+			status := "ok"
+			if !c.OK {
+				status = "FAIL"
+				healthy = false
+			}
+			rows = append(rows, []string{c.Name, status, c.Detail})
+		}
+		printResult(checks, []string{"Check", "Status", "Detail"}, rows)
+		if !healthy {
+			os.Exit(1)
+		}
 	},
 }
 
-func init() {
-	rootCmd.AddCommand(doctorCmd)
+// checkConfigFiles reports the config files loaded and whether they are valid
+func checkConfigFiles() DoctorCheck {
+	if len(cfgFiles) == 0 {
+		return DoctorCheck{"Config", false, "no config file found"}
+	}
+	if problems := validateConfig(); len(problems) > 0 {
+		return DoctorCheck{"Config", false, problems[0].String()}
+	}
+	return DoctorCheck{"Config", true, strings.Join(cfgFiles, ", ")}
+}
+
+// checkTool reports whether a binary guttu runs is on the PATH
+func checkTool(name string) DoctorCheck {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return DoctorCheck{name, false, "not found in PATH"}
+	}
+	return DoctorCheck{name, true, path}
+}
 
-	// Here you will define your flags and configuration settings.
+// checkVault reports whether Vault Agent is used and whether every Vault address, or the agent, answers
+func checkVault() []DoctorCheck {
+	var checks []DoctorCheck
+	name := "Vault"
+	if agentInUse() {
+		checks = append(checks, DoctorCheck{"Vault Agent", true, "in use at " + agentAddress()})
+		name = "Vault Agent reachable"
+	} else {
+		checks = append(checks, DoctorCheck{"Vault Agent", true, "not in use"})
+	}
 
-	// Cobra supports Persistent Flags which will work for this command
-	// and all subcommands, e.g.:
-	// doctorCmd.PersistentFlags().String("foo", "", "A help for foo")
+	for _, addr := range vaultAddresses() {
+		target := addr
+		if agentInUse() {
+			target = agentAddress()
+		}
+		var health VaultHealthResponse
+		// a single attempt, retrying would only hide which address fails
+		err := vaultSend(addr, "", "GET", "sys/health", nil, &health)
+		if vaultErr, ok := err.(*VaultErrorResponse); ok {
+			// sys/health answers with an error status for standby and sealed nodes
+			code := vaultErr.StatusCode
+			checks = append(checks, DoctorCheck{name, code == 429 || code == 473, target + ": " + vaultHealthStatus(code)})
+		} else if err != nil {
+			checks = append(checks, DoctorCheck{name, false, err.Error()})
+		} else {
+			checks = append(checks, DoctorCheck{name, true, target + ": active, version " + health.Version})
+		}
+	}
+	return checks
+}
+
+// vaultHealthStatus describes the status codes of sys/health
+func vaultHealthStatus(code int) string {
+	switch code {
+	case 429:
+		return "standby"
+	case 472:
+		return "disaster recovery secondary"
+	case 473:
+		return "performance standby"
+	case 501:
+		return "not initialized"
+	case 503:
+		return "sealed"
+	}
+	return "unexpected status " + strconv.Itoa(code)
+}
+
+// checkStoredToken reports whether the token stored by guttu login is still valid
+func checkStoredToken() DoctorCheck {
+	vaultUserToken = readStoredToken()
+	if vaultUserToken == "" {
+		detail := "none, guttu ssh logs in"
+		if agentInUse() {
+			detail = "none, Vault Agent authenticates"
+		}
+		return DoctorCheck{"Stored token", true, detail}
+	}
+	lookup, err := lookupToken()
+	if err != nil {
+		return DoctorCheck{"Stored token", false, err.Error() + ", run guttu login"}
+	}
+	return DoctorCheck{"Stored token", true, "valid, belongs to " + lookup.Data.DisplayName}
+}
+
+// checkSSHAlgorithms reports the algorithms the native client negotiates with every server, probing them all at once
+func checkSSHAlgorithms() []DoctorCheck {
+	checks := make([]DoctorCheck, len(cfg.Servers))
+	var wg sync.WaitGroup
+	for i, s := range cfg.Servers {
+		wg.Add(1)
+		go func(i int, s GuttuServerStruct) {
+			defer wg.Done()
+			name := "SSH " + s.ServerName
+			n, err := probeSSHAlgorithms(s)
+			if err != nil {
+				checks[i] = DoctorCheck{name, false, err.Error()}
+				return
+			}
+			detail := fmt.Sprintf("native client: kex %s, host key %s, cipher %s, mac %s", n.KEX, n.HostKey, n.Cipher, n.MAC)
+			if cfg.SSHBackend != "native" {
+				detail += " (with sshpass OpenSSH negotiates its own)"
+			}
+			checks[i] = DoctorCheck{name, true, detail}
+		}(i, s)
+	}
+	wg.Wait()
+	return checks
+}
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
 
-	// Cobra supports local flags which will only run when this command
-	// is called directly, e.g.:
-	// doctorCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+	doctorCmd.Flags().BoolVar(&doctorSSHFlag, "ssh", false, "also probe the SSH algorithms the native client negotiates with every server")
 }
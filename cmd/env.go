@@ -0,0 +1,99 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var remoteCommandFlag string
+
+// remoteCommand returns the command run instead of the login shell, "" for the shell
+func remoteCommand() string {
+	if remoteCommandFlag != "" {
+		return remoteCommandFlag
+	}
+	return selectedServer.RemoteCommand
+}
+
+// sessionEnv returns the variables sent to the selected server: the local ones
+// matching its send_env patterns, then its set_env ones, in NAME=value form
+func sessionEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name := strings.SplitN(kv, "=", 2)[0]
+		// TERM goes with the pseudo terminal request
+		if name == "TERM" {
+			continue
+		}
+		for _, pattern := range selectedServer.SendEnv {
+			if ok, _ := filepath.Match(pattern, name); ok {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	return append(env, selectedServer.SetEnv...)
+}
+
+// sendSessionEnv sets the variables of the selected server in the session.
+// sshd drops those its AcceptEnv doesn't list, which isn't an error.
+func sendSessionEnv(session *ssh.Session) {
+	for _, kv := range sessionEnv() {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if err := session.Setenv(parts[0], parts[1]); err != nil {
+			logger.Debugf("%s refused %s, it must be listed in AcceptEnv of sshd", selectedServer.ServerName, parts[0])
+		}
+	}
+}
+
+// sshEnvOptions returns the ssh -o options sending the variables of the selected server, for the sshpass backend
+func sshEnvOptions() []string {
+	var options []string
+	for _, pattern := range selectedServer.SendEnv {
+		options = append(options, "-o", "SendEnv="+pattern)
+	}
+	// ssh only uses the first SetEnv option, all the variables go in it
+	var set []string
+	for _, kv := range selectedServer.SetEnv {
+		if strings.ContainsAny(kv, " \t") {
+			kv = `"` + strings.Replace(kv, `"`, `\"`, -1) + `"`
+		}
+		set = append(set, kv)
+	}
+	if len(set) > 0 {
+		options = append(options, "-o", "SetEnv="+strings.Join(set, " "))
+	}
+	return options
+}
+
+// validEnvAssignment reports whether a set_env entry has the NAME=value form
+func validEnvAssignment(kv string) bool {
+	parts := strings.SplitN(kv, "=", 2)
+	return len(parts) == 2 && parts[0] != "" && !strings.ContainsAny(parts[0], " \t")
+}
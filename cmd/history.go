@@ -0,0 +1,181 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+// HistoryEntry struct for a single login recorded in the history file
+type HistoryEntry struct {
+	ServerName string    `json:"server_name" yaml:"server_name"`
+	IP         string    `json:"ip" yaml:"ip"`
+	Time       time.Time `json:"time" yaml:"time"`
+	Duration   float64   `json:"duration_seconds" yaml:"duration_seconds"`
+	ExitStatus int       `json:"exit_status" yaml:"exit_status"`
+}
+
+var historyLimit int
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show your recent server logins",
+	Long:  `List the logins recorded by guttu ssh, most recent first, with their duration and exit status.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		entries, err := readHistory()
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		recent := []HistoryEntry{}
+		var rows [][]string
+		for i := len(entries) - 1; i >= 0 && i >= len(entries)-historyLimit; i-- {
+			e := entries[i]
+			recent = append(recent, e)
+			duration := time.Duration(e.Duration * float64(time.Second)).Round(time.Second)
+			rows = append(rows, []string{e.Time.Local().Format("2006-01-02 15:04:05"), e.ServerName, e.IP, duration.String(), strconv.Itoa(e.ExitStatus)})
+		}
+		printResult(recent, []string{"Time", "Server Name", "IP", "Duration", "Exit Status"}, rows)
+	},
+}
+
+// historyFilePath returns the location of the history file, ~/.guttu_history unless configured
+func historyFilePath() (string, error) {
+	if cfg.HistoryFile != "" {
+		return homedir.Expand(cfg.HistoryFile)
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".guttu_history"), nil
+}
+
+// readHistory returns all the recorded logins, oldest first
+func readHistory() ([]HistoryEntry, error) {
+	path, err := historyFilePath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e HistoryEntry
+		// skip lines we can't make sense of instead of refusing to work
+		if json.Unmarshal(scanner.Bytes(), &e) == nil {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// appendHistory records a login at the end of the history file
+func appendHistory(e HistoryEntry) error {
+	path, err := historyFilePath()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	return err
+}
+
+// lastServer returns the configured server of the most recent login
+func lastServer() (GuttuServerStruct, bool) {
+	entries, _ := readHistory()
+	for i := len(entries) - 1; i >= 0; i-- {
+		if s, ok := findServer(entries[i].ServerName); ok {
+			return s, true
+		}
+	}
+	return GuttuServerStruct{}, false
+}
+
+// frecencyWeight gives recent logins more weight than old ones
+func frecencyWeight(age time.Duration) float64 {
+	switch {
+	case age < 4*time.Hour:
+		return 100
+	case age < 24*time.Hour:
+		return 70
+	case age < 7*24*time.Hour:
+		return 50
+	case age < 30*24*time.Hour:
+		return 30
+	default:
+		return 10
+	}
+}
+
+// pickerOrder returns the configured servers with favourites first, then
+// ordered by frecency (how often and how recently they were logged into)
+func pickerOrder() []GuttuServerStruct {
+	scores := map[string]float64{}
+	entries, _ := readHistory()
+	now := time.Now()
+	for _, e := range entries {
+		scores[e.ServerName] += frecencyWeight(now.Sub(e.Time))
+	}
+
+	servers := make([]GuttuServerStruct, len(cfg.Servers))
+	copy(servers, cfg.Servers)
+	sort.SliceStable(servers, func(i, j int) bool {
+		if servers[i].Favourite != servers[j].Favourite {
+			return servers[i].Favourite
+		}
+		return scores[servers[i].ServerName] > scores[servers[j].ServerName]
+	})
+	return servers
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+
+	historyCmd.Flags().IntVarP(&historyLimit, "limit", "n", 20, "number of logins to show")
+}
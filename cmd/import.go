@@ -0,0 +1,233 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+var sshConfigFile string
+var importRoleMap []string
+var importDefaultRole string
+var importHosts []string
+var importDryRun bool
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import servers from other tools",
+	Long:  `Commands for adding servers to your config file from the configuration of other tools.`,
+}
+
+var importSSHConfigCmd = &cobra.Command{
+	Use:   "ssh-config",
+	Short: "Import servers from your OpenSSH client config",
+	Long: `Add the hosts of ~/.ssh/config to your config file. Host, HostName, User,
+Port and ProxyJump are read, following Include directives and applying wildcard
+Host blocks the way ssh does. Host names are resolved to IPs.
+
+The vault_role of each server comes from the first matching --role-map
+(e.g. --role-map 'prod-*=prod-role'), then --role, then the existing server of
+the same name, else it is asked for. Use --dry-run to only show the changes.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		file, err := homedir.Expand(sshConfigFile)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		blocks, err := parseSSHConfig(file)
+		if err != nil {
+			logger.Fatal("Unable to read ssh config:", err)
+		}
+
+		f := loadServersForEdit()
+		added, changed := 0, 0
+		for _, h := range sshConfigHosts(blocks) {
+			if len(importHosts) > 0 && !sshPatternListMatch(importHosts, h.Alias) {
+				continue
+			}
+			imported, err := serverFromSSHHost(h)
+			if err != nil {
+				logger.Warnf("Skipping %s: %s", h.Alias, err)
+				continue
+			}
+
+			i := f.index(imported.ServerName)
+			if i == -1 {
+				if imported.VaultRole == "" && !importDryRun {
+					imported.VaultRole = promptVaultRole(imported.ServerName)
+				}
+				if imported.VaultRole == "" && !importDryRun {
+					logger.Warnf("Skipping %s: no vault_role", h.Alias)
+					continue
+				}
+				printServerDiff(nil, &imported)
+				f.add(imported)
+				added++
+				continue
+			}
+
+			updated := f.items[i].server
+			updated.IP = imported.IP
+			updated.LoginUsername = imported.LoginUsername
+			updated.Port = imported.Port
+			updated.ProxyJump = imported.ProxyJump
+			if imported.VaultRole != "" {
+				updated.VaultRole = imported.VaultRole
+			}
+			if printServerDiff(&f.items[i].server, &updated) {
+				f.items[i].server = updated
+				changed++
+			}
+		}
+
+		fmt.Printf("%d to add, %d to change in %s\n", added, changed, f.path)
+		if importDryRun || added+changed == 0 {
+			return
+		}
+		saveServers(f)
+		fmt.Println("Imported servers into", f.path)
+	},
+}
+
+// serverFromSSHHost converts a Host entry into a server, resolving its host name to an IP
+func serverFromSSHHost(h sshConfigHost) (GuttuServerStruct, error) {
+	s := GuttuServerStruct{
+		ServerName:    h.Alias,
+		LoginUsername: h.User,
+		ProxyJump:     h.ProxyJump,
+		VaultRole:     mappedVaultRole(h.Alias),
+	}
+
+	if net.ParseIP(h.HostName) != nil {
+		s.IP = h.HostName
+	} else {
+		ips, err := net.LookupIP(h.HostName)
+		if err != nil || len(ips) == 0 {
+			return s, fmt.Errorf("unable to resolve %s", h.HostName)
+		}
+		s.IP = ips[0].String()
+		// Vault OTP roles are usually given IPv4 CIDRs, prefer those
+		for _, ip := range ips {
+			if ip.To4() != nil {
+				s.IP = ip.String()
+				break
+			}
+		}
+	}
+
+	if h.Port != "" {
+		port, err := strconv.Atoi(h.Port)
+		if err != nil {
+			return s, fmt.Errorf("invalid port %q", h.Port)
+		}
+		if port != 22 {
+			s.Port = port
+		}
+	}
+
+	if s.LoginUsername == "" {
+		// ssh falls back to the local user name
+		if u, err := user.Current(); err == nil {
+			s.LoginUsername = u.Username
+		}
+	}
+	return s, nil
+}
+
+// mappedVaultRole returns the role of the first --role-map pattern matching the alias, else --role
+func mappedVaultRole(alias string) string {
+	for _, mapping := range importRoleMap {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) == 2 && sshPatternMatch(parts[0], alias) {
+			return parts[1]
+		}
+	}
+	return importDefaultRole
+}
+
+// promptVaultRole asks for the vault_role of an imported server
+func promptVaultRole(name string) string {
+	if !isInteractive() {
+		return ""
+	}
+	var role string
+	fmt.Fprintf(os.Stderr, "Enter the Vault role for %s (empty to skip): ", name)
+	fmt.Scanln(&role)
+	return strings.TrimSpace(role)
+}
+
+// printServerDiff prints how a server changes, old being nil for a new
+// server, and reports whether anything changed
+func printServerDiff(old, updated *GuttuServerStruct) bool {
+	oldMap := map[string]interface{}{}
+	if old != nil {
+		oldMap = serverToMap(*old)
+	}
+	newMap := serverToMap(*updated)
+
+	var lines []string
+	for _, key := range knownServerKeys {
+		before, after := fmt.Sprint(oldMap[key]), fmt.Sprint(newMap[key])
+		if old == nil && key == "vault_role" && updated.VaultRole == "" {
+			after = "(asked for on import)"
+		}
+		if before == after {
+			continue
+		}
+		if _, ok := oldMap[key]; ok {
+			lines = append(lines, fmt.Sprintf("-   %s: %s", key, before))
+		}
+		if _, ok := newMap[key]; ok {
+			lines = append(lines, fmt.Sprintf("+   %s: %s", key, after))
+		}
+	}
+	if len(lines) == 0 {
+		return false
+	}
+	if old == nil {
+		fmt.Println("+ server", updated.ServerName)
+	} else {
+		fmt.Println("~ server", updated.ServerName)
+	}
+	fmt.Println(strings.Join(lines, "\n"))
+	return true
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importSSHConfigCmd)
+
+	importSSHConfigCmd.Flags().StringVar(&sshConfigFile, "file", filepath.Join("~", ".ssh", "config"), "ssh config file to import")
+	importSSHConfigCmd.Flags().StringArrayVar(&importRoleMap, "role-map", nil, "pattern=role, Vault role of the hosts matching the pattern (repeatable)")
+	importSSHConfigCmd.Flags().StringVar(&importDefaultRole, "role", "", "Vault role of the hosts not matching any --role-map")
+	importSSHConfigCmd.Flags().StringArrayVar(&importHosts, "host", nil, "only import the hosts matching this pattern (repeatable)")
+	importSSHConfigCmd.Flags().BoolVar(&importDryRun, "dry-run", false, "only show the changes, don't write the config file")
+}
@@ -0,0 +1,95 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultKeepaliveInterval = 30 * time.Second
+	defaultKeepaliveCountMax = 3
+	// how long logging into a server may take, a NAT dropping packets would block it forever
+	sshConnectTimeout = 30 * time.Second
+)
+
+// keepaliveInterval returns how often a connection to a server is checked
+func keepaliveInterval() time.Duration {
+	if cfg.KeepaliveInterval > 0 {
+		return cfg.KeepaliveInterval
+	}
+	return defaultKeepaliveInterval
+}
+
+// keepaliveCountMax returns how many keepalives in a row may go unanswered before a connection is given up
+func keepaliveCountMax() int {
+	if cfg.KeepaliveCountMax > 0 {
+		return cfg.KeepaliveCountMax
+	}
+	return defaultKeepaliveCountMax
+}
+
+// startKeepalive sends keepalive@openssh.com requests on the connection, like
+// ssh's ServerAliveInterval, so NATs and firewalls don't drop it while idle.
+// It closes the connection once keepaliveCountMax of them went unanswered,
+// which ends whatever waits on it. The returned function stops it.
+func startKeepalive(conn ssh.Conn, serverName string) func() {
+	interval, countMax := keepaliveInterval(), keepaliveCountMax()
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		// a single keepalive is in flight at a time, a dead connection never answers it
+		replies := make(chan error, 1)
+		pending, missed := false, 0
+		for {
+			select {
+			case <-stop:
+				return
+			case err := <-replies:
+				if err != nil {
+					// the connection is closed already
+					return
+				}
+				pending, missed = false, 0
+			case <-ticker.C:
+				if pending {
+					missed++
+					if missed >= countMax {
+						logger.Warnf("%s didn't answer %d keepalives, closing the connection", serverName, missed)
+						conn.Close()
+						return
+					}
+					continue
+				}
+				pending = true
+				go func() {
+					// servers answer with a failure, any answer proves the connection is alive
+					_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+					replies <- err
+				}()
+			}
+		}
+	}()
+	return func() { close(stop) }
+}
@@ -0,0 +1,266 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/viper"
+)
+
+// maxIncludeDepth stops include directives from nesting (or looping) forever
+const maxIncludeDepth = 8
+
+// configOrigin struct for where a config value was read from
+type configOrigin struct {
+	File   string
+	Server int // position in the servers list of File, -1 for other keys
+}
+
+// configLoader merges config files into a single set of settings. Later files
+// override earlier ones key by key, maps are merged recursively and servers
+// are merged by server_name: an entry naming a known server overrides only the
+// fields it sets, other entries are appended to the list.
+type configLoader struct {
+	settings map[string]interface{}
+	servers  []map[string]interface{}
+	origins  map[string]configOrigin
+	files    []string
+	problems []ConfigProblem
+	loading  map[string]bool
+}
+
+func newConfigLoader() *configLoader {
+	return &configLoader{
+		settings: map[string]interface{}{},
+		origins:  map[string]configOrigin{},
+		loading:  map[string]bool{},
+	}
+}
+
+// configLayerFiles returns the config files to load, lowest precedence first:
+// the system file, the XDG user file, ~/.guttu.yaml, ./.guttu.yaml and the --config file
+func configLayerFiles() []string {
+	home, err := homedir.Dir()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		xdg = filepath.Join(home, ".config")
+	}
+
+	var files, absolute []string
+	for _, file := range []string{
+		"/etc/guttu/config.yaml",
+		filepath.Join(xdg, "guttu", "config.yaml"),
+		filepath.Join(home, ".guttu.yaml"),
+		".guttu.yaml",
+		cfgFile,
+	} {
+		if file == "" {
+			continue
+		}
+		// the --config file is always loaded, so a missing one is reported
+		if _, err := os.Stat(file); err != nil && file != cfgFile {
+			continue
+		}
+		// running from the home directory makes ./.guttu.yaml and ~/.guttu.yaml the
+		// same file, loaded once at its highest precedence so --config stays on top
+		abs, _ := filepath.Abs(file)
+		for i := range absolute {
+			if absolute[i] == abs {
+				files = append(files[:i], files[i+1:]...)
+				absolute = append(absolute[:i], absolute[i+1:]...)
+				break
+			}
+		}
+		files = append(files, file)
+		absolute = append(absolute, abs)
+	}
+	return files
+}
+
+// load merges a config file, after the files it includes
+func (l *configLoader) load(file string, depth int) {
+	if abs, err := filepath.Abs(file); err == nil {
+		file = abs
+	}
+	if l.loading[file] {
+		l.problem(file, "include loop, the file includes itself")
+		return
+	}
+	if depth > maxIncludeDepth {
+		l.problem(file, "includes are nested more than %d levels deep", maxIncludeDepth)
+		return
+	}
+	l.loading[file] = true
+	defer delete(l.loading, file)
+
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		l.problem(file, "%s", err)
+		return
+	}
+	settings := v.AllSettings()
+
+	for _, include := range includeList(settings["include"]) {
+		include, _ = homedir.Expand(include)
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(file), include)
+		}
+		matches, err := filepath.Glob(include)
+		if err != nil || len(matches) == 0 {
+			l.problem(file, "include %q matches no file", include)
+			continue
+		}
+		for _, match := range matches {
+			l.load(match, depth+1)
+		}
+	}
+	delete(settings, "include")
+
+	l.files = append(l.files, file)
+	for key, value := range settings {
+		if key == "servers" {
+			l.mergeServers(file, value)
+			continue
+		}
+		l.settings[key] = l.merge(file, key, l.settings[key], value)
+	}
+}
+
+// merge returns value merged over old, recording where each leaf came from
+func (l *configLoader) merge(file, path string, old, value interface{}) interface{} {
+	newMap, oldMap := toStringMap(value), toStringMap(old)
+	if newMap == nil || oldMap == nil {
+		l.origins[path] = configOrigin{File: file, Server: -1}
+		if newMap != nil {
+			for key, v := range newMap {
+				l.merge(file, path+"."+key, nil, v)
+			}
+		}
+		return value
+	}
+	for key, v := range newMap {
+		oldMap[key] = l.merge(file, path+"."+key, oldMap[key], v)
+	}
+	return oldMap
+}
+
+// mergeServers merges the servers list of a file by server_name
+func (l *configLoader) mergeServers(file string, value interface{}) {
+	list, ok := value.([]interface{})
+	if !ok {
+		l.problem(file, "servers must be a list")
+		return
+	}
+	inFile := map[string]bool{}
+	for j, raw := range list {
+		entry := toStringMap(raw)
+		if entry == nil {
+			l.problem(file, "server #%d is not a map of settings", j+1)
+			continue
+		}
+		name, _ := entry["server_name"].(string)
+		i := -1
+		// a name used twice in one file is a mistake, not an override
+		if name != "" && !inFile[name] {
+			i = l.serverIndex(name)
+		}
+		if i == -1 {
+			l.servers = append(l.servers, map[string]interface{}{})
+			i = len(l.servers) - 1
+			l.origins[fmt.Sprintf("servers.%d", i)] = configOrigin{File: file, Server: j}
+		}
+		inFile[name] = true
+		for key, v := range entry {
+			l.servers[i][key] = v
+			l.origins[fmt.Sprintf("servers.%d.%s", i, key)] = configOrigin{File: file, Server: j}
+		}
+	}
+}
+
+// serverIndex returns the position of the named server in the merged list, -1 when unknown
+func (l *configLoader) serverIndex(name string) int {
+	for i := len(l.servers) - 1; i >= 0; i-- {
+		if l.servers[i]["server_name"] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (l *configLoader) problem(file string, format string, a ...interface{}) {
+	l.problems = append(l.problems, ConfigProblem{File: file, Message: fmt.Sprintf(format, a...)})
+}
+
+// merged returns the merged settings, servers included
+func (l *configLoader) merged() map[string]interface{} {
+	out := map[string]interface{}{}
+	for key, value := range l.settings {
+		out[key] = value
+	}
+	if len(l.servers) > 0 {
+		servers := make([]interface{}, len(l.servers))
+		for i, s := range l.servers {
+			servers[i] = s
+		}
+		out["servers"] = servers
+	}
+	return out
+}
+
+// includeList accepts a single include or a list of them
+func includeList(raw interface{}) []string {
+	switch v := raw.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var list []string
+		for _, item := range v {
+			list = append(list, fmt.Sprint(item))
+		}
+		return list
+	}
+	return nil
+}
+
+// configOriginOf returns where the value of a key was read from, key being
+// either a top level path such as "vault_address" or "servers.<n>.<key>"
+func configOriginOf(key string) (configOrigin, bool) {
+	for {
+		if origin, ok := cfgOrigins[key]; ok {
+			return origin, true
+		}
+		i := strings.LastIndex(key, ".")
+		if i == -1 {
+			return configOrigin{}, false
+		}
+		key = key[:i]
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+func TestConfigFlagStaysTopLayer(t *testing.T) {
+	home, work := t.TempDir(), t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	homedir.DisableCache = true
+	defer func() { homedir.DisableCache = false }()
+
+	homeFile := filepath.Join(home, ".guttu.yaml")
+	if err := ioutil.WriteFile(homeFile, []byte("vault_address: https://home:8200\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(work, ".guttu.yaml"), []byte("vault_address: https://work:8200\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	savedCfgFile := cfgFile
+	defer func() { cfgFile = savedCfgFile }()
+	cfgFile = homeFile
+
+	files := configLayerFiles()
+	if len(files) != 2 || files[0] != ".guttu.yaml" || files[1] != homeFile {
+		t.Fatalf("layers = %q, want ./.guttu.yaml then %s", files, homeFile)
+	}
+	loader := newConfigLoader()
+	for _, file := range files {
+		loader.load(file, 0)
+	}
+	if addr := loader.merged()["vault_address"]; addr != "https://home:8200" {
+		t.Errorf("vault_address = %v, want the one of the --config file", addr)
+	}
+}
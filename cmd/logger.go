@@ -0,0 +1,152 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is how much guttu tells about what it does, from errors only to every Vault request
+type logLevel int
+
+const (
+	levelError logLevel = iota
+	levelWarn
+	levelInfo
+	levelDebug
+	levelTrace
+)
+
+var levelNames = []string{"error", "warn", "info", "debug", "trace"}
+
+var verbosity int
+var quiet bool
+var logFormat string
+
+// leveledLogger writes log lines at or below its level to stderr, as plain text or JSON
+type leveledLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	level  logLevel
+	format string
+}
+
+var logger = &leveledLogger{out: os.Stderr, level: levelInfo, format: "text"}
+
+// setupLogger applies -v, -vv, --quiet and --log-format
+func setupLogger() {
+	switch {
+	case quiet:
+		logger.level = levelError
+	case verbosity >= 2:
+		logger.level = levelTrace
+	case verbosity == 1:
+		logger.level = levelDebug
+	}
+	if logFormat != "text" && logFormat != "json" {
+		logger.Fatalf("Unknown log format %q, use text or json", logFormat)
+	}
+	logger.format = logFormat
+}
+
+func (l *leveledLogger) log(level logLevel, msg string) {
+	if level > l.level {
+		return
+	}
+	l.write(level, msg)
+}
+
+// write writes a line whatever the level of the logger
+func (l *leveledLogger) write(level logLevel, msg string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	msg = strings.TrimSuffix(msg, "\n")
+	if l.format == "json" {
+		line, _ := json.Marshal(struct {
+			Time  time.Time `json:"time"`
+			Level string    `json:"level"`
+			Msg   string    `json:"msg"`
+		}{time.Now(), levelNames[level], msg})
+		fmt.Fprintln(l.out, string(line))
+		return
+	}
+	fmt.Fprintln(l.out, msg)
+}
+
+// Enabled reports whether lines of the level are written, to skip building costly ones
+func (l *leveledLogger) Enabled(level logLevel) bool {
+	return level <= l.level
+}
+
+// Error logs its operands with spaces between them, like fmt.Sprintln
+func (l *leveledLogger) Error(v ...interface{}) { l.log(levelError, fmt.Sprintln(v...)) }
+
+// Errorf logs according to a format, like fmt.Sprintf
+func (l *leveledLogger) Errorf(format string, v ...interface{}) {
+	l.log(levelError, fmt.Sprintf(format, v...))
+}
+
+// Warn logs its operands with spaces between them, like fmt.Sprintln
+func (l *leveledLogger) Warn(v ...interface{}) { l.log(levelWarn, fmt.Sprintln(v...)) }
+
+// Warnf logs according to a format, like fmt.Sprintf
+func (l *leveledLogger) Warnf(format string, v ...interface{}) {
+	l.log(levelWarn, fmt.Sprintf(format, v...))
+}
+
+// Info logs its operands with spaces between them, like fmt.Sprintln
+func (l *leveledLogger) Info(v ...interface{}) { l.log(levelInfo, fmt.Sprintln(v...)) }
+
+// Infof logs according to a format, like fmt.Sprintf
+func (l *leveledLogger) Infof(format string, v ...interface{}) {
+	l.log(levelInfo, fmt.Sprintf(format, v...))
+}
+
+// Debug logs its operands with spaces between them, like fmt.Sprintln
+func (l *leveledLogger) Debug(v ...interface{}) { l.log(levelDebug, fmt.Sprintln(v...)) }
+
+// Debugf logs according to a format, like fmt.Sprintf
+func (l *leveledLogger) Debugf(format string, v ...interface{}) {
+	l.log(levelDebug, fmt.Sprintf(format, v...))
+}
+
+// Tracef logs according to a format, like fmt.Sprintf
+func (l *leveledLogger) Tracef(format string, v ...interface{}) {
+	l.log(levelTrace, fmt.Sprintf(format, v...))
+}
+
+// Fatal logs like Error, then exits with status 1
+func (l *leveledLogger) Fatal(v ...interface{}) {
+	l.Error(v...)
+	os.Exit(1)
+}
+
+// Fatalf logs like Errorf, then exits with status 1
+func (l *leveledLogger) Fatalf(format string, v ...interface{}) {
+	l.Errorf(format, v...)
+	os.Exit(1)
+}
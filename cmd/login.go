@@ -0,0 +1,245 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/howeyc/gopass"
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+var vaultUserToken string
+
+// vaultTokenStored is set when the token in use is kept outside of guttu ssh,
+// by guttu login or Vault Agent, so the session must not revoke it
+var vaultTokenStored bool
+
+// vaultTokenSource is where the token in use was read from when guttu didn't
+// log in for it, such as VAULT_TOKEN, "" for tokens guttu logged in for
+var vaultTokenSource string
+var loginMethod string
+var loginUsername string
+
+// loginCmd represents the login command
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Login to Vault and store the token",
+	Long: `Login to Vault and store the token, so the following commands don't ask
+for your credentials again until you run guttu logout or the token expires.
+
+The auth method is taken from --method, else auth_method in the config file,
+else userpass. Supported methods: userpass, ldap, okta, radius, token, oidc,
+jwt and approle. Without a terminal (cron jobs, CI runners) guttu never
+prompts: use approle, jwt or token with token_file or VAULT_TOKEN.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if agentInUse() {
+			fmt.Println("Vault Agent at", agentAddress(), "authenticates requests, no login needed")
+			return
+		}
+		vaultLogin(authMethod())
+		if err := storeToken(); err != nil {
+			logger.Fatal("Unable to store the Vault token:", err)
+		}
+		fmt.Println("Logged into Vault, token stored in", tokenStorePath())
+	},
+}
+
+// authMethod returns the auth method to login with
+func authMethod() string {
+	if loginMethod != "" {
+		return loginMethod
+	}
+	if cfg.AuthMethod != "" {
+		return cfg.AuthMethod
+	}
+	return "userpass"
+}
+
+// authMount returns the path the auth method is mounted at, the method name unless configured
+func authMount(method string) string {
+	if cfg.AuthMount != "" {
+		return strings.Trim(cfg.AuthMount, "/")
+	}
+	return method
+}
+
+// ensureVaultToken uses the token stored by guttu login when it is still
+// valid, and logs into Vault otherwise. Nothing is needed with Vault Agent.
+func ensureVaultToken() {
+	if agentInUse() {
+		logger.Info("Using Vault Agent at", agentAddress())
+		audit(AuditEvent{Event: auditTokenReuse, VaultAddress: agentAddress(), TokenSource: "agent"})
+		return
+	}
+	if token, source := readTokenStore(); token != "" {
+		vaultUserToken, vaultTokenSource = token, source
+		if _, err := lookupToken(); err == nil {
+			vaultTokenStored = true
+			logger.Info("Using the Vault token from guttu login")
+			audit(AuditEvent{Event: auditTokenReuse, VaultAddress: vaultAddress(), TokenSource: "stored"})
+			return
+		}
+		vaultUserToken, vaultTokenSource = "", ""
+		logger.Warn("The stored Vault token is no longer valid, logging in again")
+	}
+	vaultLogin(authMethod())
+}
+
+// vaultLogin logs into Vault with the given auth method and keeps the token in vaultUserToken
+func vaultLogin(method string) {
+	switch method {
+	case "userpass", "ldap", "okta", "radius":
+		showVaultLoginPrompt(authMount(method))
+	case "token":
+		if cfg.TokenFile != "" || os.Getenv("VAULT_TOKEN") != "" {
+			tokenFromFile()
+		} else {
+			showVaultTokenPrompt()
+		}
+	case "approle":
+		appRoleLogin(authMount(method))
+	case "oidc":
+		oidcLogin(authMount(method))
+	case "jwt":
+		jwtLogin(authMount(method))
+	default:
+		logger.Fatalf("Unknown auth method %q", method)
+	}
+}
+
+// showVaultLoginPrompt logs in with a user name and password, for the auth
+// methods sharing the <mount>/login/<username> endpoint
+func showVaultLoginPrompt(mount string) {
+	requireInteractiveLogin("Logging in with a password")
+	vaultUsername := loginUsername
+	if vaultUsername == "" {
+		fmt.Fprint(os.Stderr, "Enter your Vault user name: ")
+		fmt.Scanln(&vaultUsername)
+	}
+	fmt.Fprint(os.Stderr, "Enter your Vault password: ")
+	vaultPassword, _ := gopass.GetPasswd()
+	defer wipeBytes(vaultPassword)
+	logger.Info("Logging into Vault...")
+
+	loginResponse, err := passwordLogin(mount, vaultUsername, vaultPassword)
+	if err != nil {
+		exitOnVaultError(err)
+	}
+	finishLogin(loginResponse)
+}
+
+// passwordLogin sends a user name and password to <mount>/login/<username>
+func passwordLogin(mount, username string, password Secret) (VaultAuthLoginResponse, error) {
+	loginResponse := VaultAuthLoginResponse{}
+	err := vaultRequest("POST", "auth/"+mount+"/login/"+url.PathEscape(username), VaultPasswordLoginRequest{Password: password}, &loginResponse)
+	return loginResponse, err
+}
+
+// showVaultTokenPrompt asks for an existing Vault token and checks it is valid
+func showVaultTokenPrompt() {
+	requireInteractiveLogin("Asking for a Vault token")
+	fmt.Fprint(os.Stderr, "Enter your Vault token: ")
+	token, _ := gopass.GetPasswd()
+	vaultUserToken = strings.TrimSpace(string(token))
+	wipeBytes(token)
+	if _, err := lookupToken(); err != nil {
+		vaultUserToken = ""
+		exitOnVaultError(err)
+	}
+	logger.Info("Logged into Vault...")
+	audit(AuditEvent{Event: auditLogin, VaultAddress: vaultAddress(), AuthMethod: "token"})
+}
+
+// tokenStorePath returns where guttu login stores the token, ~/.guttu-token unless configured
+func tokenStorePath() string {
+	if cfg.TokenStore != "" {
+		path, err := homedir.Expand(cfg.TokenStore)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		return path
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return filepath.Join(home, ".guttu-token")
+}
+
+// readStoredToken returns the token stored by guttu login, "" when there is none
+func readStoredToken() string {
+	token, _ := readTokenStore()
+	return token
+}
+
+// readTokenStore returns the token stored by guttu login and its source, on
+// the line after the token when guttu didn't log in for it
+func readTokenStore() (string, string) {
+	data, err := ioutil.ReadFile(tokenStorePath())
+	if err != nil {
+		return "", ""
+	}
+	lines := strings.SplitN(strings.TrimSpace(string(data)), "\n", 2)
+	token, source := strings.TrimSpace(lines[0]), ""
+	if len(lines) == 2 {
+		source = strings.TrimPrefix(strings.TrimSpace(lines[1]), "source=")
+	}
+	return token, source
+}
+
+// storeToken stores the current token with its source, readable only by the
+// user even when the file existed with wider permissions
+func storeToken() error {
+	data := vaultUserToken
+	if vaultTokenSource != "" {
+		data += "\nsource=" + vaultTokenSource
+	}
+	if err := writeFileAtomic(tokenStorePath(), []byte(data+"\n"), 0600); err != nil {
+		return err
+	}
+	vaultTokenStored = true
+	return nil
+}
+
+// deleteStoredToken removes the token stored by guttu login
+func deleteStoredToken() error {
+	err := os.Remove(tokenStorePath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func init() {
+	rootCmd.AddCommand(loginCmd)
+
+	loginCmd.Flags().StringVarP(&loginMethod, "method", "m", "", "auth method: userpass, ldap, okta, radius, token, oidc, jwt or approle")
+	loginCmd.Flags().BoolVar(&noBrowser, "no-browser", false, "only print the OIDC login URL, don't open a browser")
+	loginCmd.Flags().StringVarP(&loginUsername, "username", "u", "", "user name, asked for when not given")
+}
@@ -0,0 +1,125 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// withTokenStore points the token store at path until the test ends
+func withTokenStore(t *testing.T, path string) {
+	savedCfg, savedToken, savedStored := cfg, vaultUserToken, vaultTokenStored
+	t.Cleanup(func() {
+		cfg, vaultUserToken, vaultTokenStored = savedCfg, savedToken, savedStored
+	})
+	cfg = GuttuConfigStruct{TokenStore: path}
+	vaultUserToken, vaultTokenStored = "s.token", false
+}
+
+func TestStoreTokenTightensPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := ioutil.WriteFile(path, []byte("s.old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	withTokenStore(t, path)
+	if err := storeToken(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %o, want 600", info.Mode().Perm())
+	}
+	if token := readStoredToken(); token != "s.token" {
+		t.Errorf("stored token = %q", token)
+	}
+	if !vaultTokenStored {
+		t.Error("the token isn't marked as stored")
+	}
+}
+
+func TestStoreTokenFailure(t *testing.T) {
+	withTokenStore(t, filepath.Join(t.TempDir(), "missing", "token"))
+	if err := storeToken(); err == nil {
+		t.Fatal("storing into a missing directory succeeded")
+	}
+	if vaultTokenStored {
+		t.Error("the token is marked as stored after the write failed")
+	}
+}
+
+func TestLogoutKeepsExternalToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		login   func(t *testing.T)
+		revoked bool
+	}{
+		{"token file", func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "sink")
+			if err := ioutil.WriteFile(file, []byte("s.agent\n"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			cfg.TokenFile = file
+			tokenFromFile()
+		}, false},
+		{"VAULT_TOKEN", func(t *testing.T) {
+			t.Setenv("VAULT_TOKEN", "s.env")
+			tokenFromFile()
+		}, false},
+		{"password login", func(t *testing.T) {
+			vaultUserToken = "s.minted"
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeVault(t, 200, `{"data":{"display_name":"token"}}`)
+			store := filepath.Join(t.TempDir(), "token")
+			savedSource, savedStored := vaultTokenSource, vaultTokenStored
+			defer func() { vaultTokenSource, vaultTokenStored = savedSource, savedStored }()
+			cfg.TokenStore = store
+			vaultTokenSource = ""
+
+			tt.login(t)
+			if err := storeToken(); err != nil {
+				t.Fatal(err)
+			}
+			logoutCmd.Run(logoutCmd, nil)
+
+			revoked := false
+			for _, call := range *calls {
+				if call.URI == "/v1/auth/token/revoke-self" {
+					revoked = true
+				}
+			}
+			if revoked != tt.revoked {
+				t.Errorf("revoked = %t, want %t", revoked, tt.revoked)
+			}
+			if _, err := os.Stat(store); !os.IsNotExist(err) {
+				t.Errorf("the stored token wasn't deleted: %v", err)
+			}
+		})
+	}
+}
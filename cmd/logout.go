@@ -0,0 +1,69 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var logoutKeepToken bool
+
+// logoutCmd represents the logout command
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Revoke and forget the token stored by guttu login",
+	Long: `Revoke the Vault token stored by guttu login and delete it. With
+--keep-token the token is only deleted, it stays valid until it expires.
+Tokens guttu didn't log in for, read from token_file or VAULT_TOKEN, are
+never revoked as they belong to Vault Agent or whoever set them.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		var source string
+		vaultUserToken, source = readTokenStore()
+		if vaultUserToken == "" {
+			fmt.Println("Not logged in")
+			return
+		}
+		if source != "" {
+			logger.Info("The Vault token comes from", source+", not revoking it")
+		} else if !logoutKeepToken {
+			if err := revokeToken(); err != nil {
+				// an expired token can't be revoked, but still has to go
+				logger.Warn("Unable to revoke the Vault token:", err)
+			} else {
+				logger.Info("Revoked the Vault token")
+				audit(AuditEvent{Event: auditTokenRevoked, VaultAddress: vaultAddress()})
+			}
+		}
+		if err := deleteStoredToken(); err != nil {
+			logger.Fatal("Unable to delete the stored token:", err)
+		}
+		fmt.Println("Logged out")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+
+	logoutCmd.Flags().BoolVar(&logoutKeepToken, "keep-token", false, "delete the stored token without revoking it")
+}
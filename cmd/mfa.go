@@ -0,0 +1,149 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/howeyc/gopass"
+)
+
+// VaultMFARequirement struct for the MFA requirement of a login response
+type VaultMFARequirement struct {
+	MFARequestID   string `json:"mfa_request_id"`
+	MFAConstraints map[string]struct {
+		Any []VaultMFAMethod `json:"any"`
+	} `json:"mfa_constraints"`
+}
+
+// VaultMFAMethod struct for one of the MFA methods that can satisfy a constraint
+type VaultMFAMethod struct {
+	Type         string `json:"type"`
+	ID           string `json:"id"`
+	UsesPasscode bool   `json:"uses_passcode"`
+	Name         string `json:"name"`
+}
+
+// VaultMFAValidateRequest struct for the payload completing an MFA login
+type VaultMFAValidateRequest struct {
+	MFARequestID string              `json:"mfa_request_id"`
+	MFAPayload   map[string][]Secret `json:"mfa_payload"`
+}
+
+// mfaPrompter returns the passcode for an MFA method, empty for push
+// methods approved on another device
+type mfaPrompter func(constraint string, method VaultMFAMethod) (Secret, error)
+
+// promptMFA asks for MFA passcodes, it can be replaced to drive the MFA flow without a terminal
+var promptMFA mfaPrompter = promptMFAOnTerminal
+
+// mfaValidate sends the MFA payload to Vault, it can be replaced to stub Vault
+var mfaValidate = func(request VaultMFAValidateRequest, out *VaultAuthLoginResponse) error {
+	return vaultRequest("POST", "sys/mfa/validate", request, out)
+}
+
+// completeMFA satisfies every constraint of an MFA requirement with the first
+// method it allows and returns the login response holding the token
+func completeMFA(requirement *VaultMFARequirement, prompt mfaPrompter) (VaultAuthLoginResponse, error) {
+	request := VaultMFAValidateRequest{
+		MFARequestID: requirement.MFARequestID,
+		MFAPayload:   map[string][]Secret{},
+	}
+	defer func() {
+		for _, passcodes := range request.MFAPayload {
+			for _, p := range passcodes {
+				p.Wipe()
+			}
+		}
+	}()
+
+	names := make([]string, 0, len(requirement.MFAConstraints))
+	for name := range requirement.MFAConstraints {
+		names = append(names, name)
+	}
+	// ask for passcodes first, so a push isn't left waiting while one is typed
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := usesPasscode(requirement, names[i]), usesPasscode(requirement, names[j])
+		if pi != pj {
+			return pi
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		methods := requirement.MFAConstraints[name].Any
+		if len(methods) == 0 {
+			return VaultAuthLoginResponse{}, fmt.Errorf("MFA constraint %q allows no method", name)
+		}
+		method := methods[0]
+		passcode, err := prompt(name, method)
+		if err != nil {
+			return VaultAuthLoginResponse{}, err
+		}
+		if passcode == nil {
+			// push methods take an empty passcode and wait for the approval
+			passcode = Secret{}
+		}
+		// constraints satisfied by the same method each need their passcode
+		request.MFAPayload[method.ID] = append(request.MFAPayload[method.ID], passcode)
+	}
+
+	response := VaultAuthLoginResponse{}
+	err := mfaValidate(request, &response)
+	return response, err
+}
+
+// usesPasscode reports whether the method used for a constraint takes a passcode
+func usesPasscode(requirement *VaultMFARequirement, constraint string) bool {
+	methods := requirement.MFAConstraints[constraint].Any
+	return len(methods) > 0 && methods[0].UsesPasscode
+}
+
+// promptMFAOnTerminal asks for a passcode, or tells the user to approve the push notification
+func promptMFAOnTerminal(constraint string, method VaultMFAMethod) (Secret, error) {
+	if !method.UsesPasscode {
+		fmt.Fprintf(os.Stderr, "Approve the %s push notification for %s on your device...\n", method.Type, constraint)
+		return nil, nil
+	}
+	requireInteractiveLogin("Asking for an MFA passcode")
+	fmt.Fprintf(os.Stderr, "Enter the %s passcode for %s: ", method.Type, constraint)
+	passcode, err := gopass.GetPasswd()
+	return Secret(passcode), err
+}
+
+// finishLogin completes an MFA login when Vault requires it and keeps the token
+func finishLogin(loginResponse VaultAuthLoginResponse) {
+	if requirement := loginResponse.Auth.MFARequirement; requirement != nil {
+		logger.Info("Vault requires MFA to complete the login")
+		var err error
+		loginResponse, err = completeMFA(requirement, promptMFA)
+		if err != nil {
+			exitOnVaultError(err)
+		}
+	}
+	if loginResponse.Auth.ClientToken == "" {
+		logger.Fatal("Error: Vault returned no token")
+	}
+	logger.Info("Logged into Vault...")
+	vaultUserToken = loginResponse.Auth.ClientToken
+	audit(AuditEvent{Event: auditLogin, VaultAddress: vaultAddress(), AuthMethod: authMethod()})
+}
@@ -0,0 +1,151 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// stubMFA replaces the MFA seams for the test: prompt answers with the
+// passcodes by constraint and validate with a token when they match want
+func stubMFA(t *testing.T, passcodes map[string]string, want map[string][]string) *[]string {
+	savedPrompt, savedValidate, savedToken := promptMFA, mfaValidate, vaultUserToken
+	t.Cleanup(func() {
+		promptMFA, mfaValidate, vaultUserToken = savedPrompt, savedValidate, savedToken
+	})
+	t.Setenv("VAULT_AGENT_ADDR", "")
+	savedCfg := cfg
+	t.Cleanup(func() { cfg = savedCfg })
+	cfg = GuttuConfigStruct{VaultAddress: "http://127.0.0.1:1", AuditLog: "off"}
+
+	var prompted []string
+	promptMFA = func(constraint string, method VaultMFAMethod) (Secret, error) {
+		prompted = append(prompted, constraint)
+		if !method.UsesPasscode {
+			return nil, nil
+		}
+		return Secret(passcodes[constraint]), nil
+	}
+	mfaValidate = func(request VaultMFAValidateRequest, out *VaultAuthLoginResponse) error {
+		got := map[string][]string{}
+		for id, codes := range request.MFAPayload {
+			for _, c := range codes {
+				got[id] = append(got[id], string(c))
+			}
+		}
+		if request.MFARequestID != "req-1" || !reflect.DeepEqual(got, want) {
+			return &VaultErrorResponse{StatusCode: 403, Path: "sys/mfa/validate", Errors: []string{"failed to validate"}}
+		}
+		out.Auth.ClientToken = "s.mfa"
+		return nil
+	}
+	return &prompted
+}
+
+// mfaRequirement builds a requirement from constraint names to their single method
+func mfaRequirement(methods map[string]VaultMFAMethod) *VaultMFARequirement {
+	r := &VaultMFARequirement{MFARequestID: "req-1"}
+	r.MFAConstraints = map[string]struct {
+		Any []VaultMFAMethod `json:"any"`
+	}{}
+	for name, m := range methods {
+		c := r.MFAConstraints[name]
+		c.Any = []VaultMFAMethod{m}
+		r.MFAConstraints[name] = c
+	}
+	return r
+}
+
+var totpMethod = VaultMFAMethod{Type: "totp", ID: "totp-id", UsesPasscode: true}
+
+func TestCompleteMFATOTP(t *testing.T) {
+	stubMFA(t, map[string]string{"otp": "123456"}, map[string][]string{"totp-id": {"123456"}})
+	response, err := completeMFA(mfaRequirement(map[string]VaultMFAMethod{"otp": totpMethod}), promptMFA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Auth.ClientToken != "s.mfa" {
+		t.Errorf("client token = %q, want s.mfa", response.Auth.ClientToken)
+	}
+}
+
+func TestCompleteMFAWrongPasscode(t *testing.T) {
+	stubMFA(t, map[string]string{"otp": "000000"}, map[string][]string{"totp-id": {"123456"}})
+	_, err := completeMFA(mfaRequirement(map[string]VaultMFAMethod{"otp": totpMethod}), promptMFA)
+	if err == nil {
+		t.Fatal("a wrong passcode was accepted")
+	}
+	if code := vaultExitCode(err); code != exitAuthFailed {
+		t.Errorf("exit code = %d, want %d", code, exitAuthFailed)
+	}
+}
+
+func TestCompleteMFAPromptError(t *testing.T) {
+	stubMFA(t, nil, nil)
+	promptMFA = func(string, VaultMFAMethod) (Secret, error) { return nil, errors.New("no terminal") }
+	if _, err := completeMFA(mfaRequirement(map[string]VaultMFAMethod{"otp": totpMethod}), promptMFA); err == nil {
+		t.Fatal("the prompt error was ignored")
+	}
+}
+
+func TestCompleteMFAMultipleConstraints(t *testing.T) {
+	duo := VaultMFAMethod{Type: "duo", ID: "duo-id"}
+	prompted := stubMFA(t,
+		map[string]string{"first": "111111", "second": "222222"},
+		map[string][]string{"totp-id": {"111111", "222222"}, "duo-id": {""}})
+	requirement := mfaRequirement(map[string]VaultMFAMethod{"first": totpMethod, "second": totpMethod, "push": duo})
+	response, err := completeMFA(requirement, promptMFA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Auth.ClientToken != "s.mfa" {
+		t.Errorf("client token = %q, want s.mfa", response.Auth.ClientToken)
+	}
+	// passcodes are asked for before the push is sent
+	if want := []string{"first", "second", "push"}; !reflect.DeepEqual(*prompted, want) {
+		t.Errorf("prompted for %v, want %v", *prompted, want)
+	}
+}
+
+func TestFinishLoginWithMFA(t *testing.T) {
+	stubMFA(t, map[string]string{"otp": "123456"}, map[string][]string{"totp-id": {"123456"}})
+	login := VaultAuthLoginResponse{}
+	login.Auth.MFARequirement = mfaRequirement(map[string]VaultMFAMethod{"otp": totpMethod})
+	finishLogin(login)
+	if vaultUserToken != "s.mfa" {
+		t.Errorf("token = %q, want s.mfa", vaultUserToken)
+	}
+}
+
+func TestFinishLoginWithoutMFA(t *testing.T) {
+	prompted := stubMFA(t, nil, nil)
+	login := VaultAuthLoginResponse{}
+	login.Auth.ClientToken = "s.plain"
+	finishLogin(login)
+	if vaultUserToken != "s.plain" {
+		t.Errorf("token = %q, want s.plain", vaultUserToken)
+	}
+	if len(*prompted) != 0 {
+		t.Errorf("prompted for %v without an MFA requirement", *prompted)
+	}
+}
@@ -0,0 +1,530 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+const defaultMultiplexIdle = 10 * time.Minute
+
+// muxStopRequest is the global request asking a master to close its connection
+const muxStopRequest = "stop@guttu"
+
+var multiplexFlag bool
+
+// MuxMaster struct for a running master connection
+type MuxMaster struct {
+	ServerName string `json:"server_name" yaml:"server_name"`
+	Socket     string `json:"socket" yaml:"socket"`
+}
+
+// muxCmd represents the mux command
+var muxCmd = &cobra.Command{
+	Use:   "mux",
+	Short: "Manage the master connections shared by sessions",
+	Long: `guttu ssh --mux (or multiplex: true in the config file) keeps the connection
+to a server open in a background master process once the session ends, like
+ssh's ControlMaster. The following sessions on the server reuse it without a
+new OTP until it stays unused for multiplex_idle (10m by default).`,
+}
+
+// muxListCmd represents the mux list command
+var muxListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the running master connections",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		masters := runningMasters()
+		rows := make([][]string, len(masters))
+		for i, m := range masters {
+			rows[i] = []string{m.ServerName, m.Socket}
+		}
+		printResult(masters, []string{"Server Name", "Socket"}, rows)
+	},
+}
+
+// muxStopCmd represents the mux stop command
+var muxStopCmd = &cobra.Command{
+	Use:   "stop [server name]",
+	Short: "Close the master connection to a server, or all of them",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		stopped := 0
+		for _, m := range runningMasters() {
+			if len(args) == 1 && m.ServerName != args[0] {
+				continue
+			}
+			client := dialMaster(m.ServerName)
+			if client == nil {
+				continue
+			}
+			client.SendRequest(muxStopRequest, true, nil)
+			client.Close()
+			logger.Info("Closed the master connection to", m.ServerName)
+			stopped++
+		}
+		if stopped == 0 && len(args) == 1 {
+			logger.Fatal("No master connection to", args[0])
+		}
+	},
+}
+
+// muxMasterCmd runs the master connection of guttu ssh, it is started by guttu itself
+var muxMasterCmd = &cobra.Command{
+	Use:    "__mux <server name>",
+	Hidden: true,
+	Args:   cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		// guttu ssh passes the OTP on fd 3 and waits for the outcome on fd 4
+		otp, ready := os.NewFile(3, "otp"), os.NewFile(4, "ready")
+		err := startMasterListener(args[0], otp, ready)
+		if err != nil {
+			fmt.Fprint(ready, err)
+			ready.Close()
+			os.Exit(1)
+		}
+	},
+}
+
+// multiplexSession reports whether sessions on the selected server share a master connection
+func multiplexSession() bool {
+	return multiplexFlag || cfg.Multiplex || selectedServer.Multiplex
+}
+
+// multiplexIdle returns how long an unused master connection is kept open
+func multiplexIdle() time.Duration {
+	if cfg.MultiplexIdle > 0 {
+		return cfg.MultiplexIdle
+	}
+	return defaultMultiplexIdle
+}
+
+// muxDir returns the directory holding the control sockets, only readable by the user
+func muxDir() (string, error) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".guttu-mux"), nil
+}
+
+// checkMuxDir checks that only the user can reach the control sockets, as they
+// hand out sessions on logged in connections without asking for anything
+func checkMuxDir(dir string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		return fmt.Errorf("%s is a symbolic link", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return checkPrivateDir(dir, info)
+}
+
+// muxSocketPath returns the control socket of the master connection to a server
+func muxSocketPath(serverName string) (string, error) {
+	dir, err := muxDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, strings.Replace(serverName, "/", "_", -1)+".sock"), nil
+}
+
+// runningMasters lists the master connections that answer, removing the sockets of those gone
+func runningMasters() []MuxMaster {
+	dir, err := muxDir()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	sockets, _ := filepath.Glob(filepath.Join(dir, "*.sock"))
+	masters := []MuxMaster{}
+	for _, socket := range sockets {
+		conn, err := net.DialTimeout("unix", socket, time.Second)
+		if err != nil {
+			os.Remove(socket)
+			continue
+		}
+		conn.Close()
+		name := strings.TrimSuffix(filepath.Base(socket), ".sock")
+		masters = append(masters, MuxMaster{ServerName: name, Socket: socket})
+	}
+	return masters
+}
+
+// dialMaster connects to the master connection to a server, nil when there is none.
+// The master speaks SSH on its control socket, so sessions use it like the server.
+func dialMaster(serverName string) *ssh.Client {
+	socket, err := muxSocketPath(serverName)
+	if err != nil {
+		return nil
+	}
+	if _, err := os.Stat(socket); err != nil {
+		return nil
+	}
+	if err := checkMuxDir(filepath.Dir(socket)); err != nil {
+		logger.Warn("Not using the master connection:", err)
+		return nil
+	}
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return nil
+	}
+	config := &ssh.ClientConfig{
+		User: selectedServer.LoginUsername,
+		// only the user can reach the socket, that is the authentication
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, socket, config)
+	if err != nil {
+		conn.Close()
+		return nil
+	}
+	return ssh.NewClient(c, chans, reqs)
+}
+
+// startMaster starts a background master connection to the selected server,
+// handing it the OTP through a pipe, and connects to it
+func startMaster() (*ssh.Client, error) {
+	defer vaultSSHOTPKey.Wipe()
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	otpReader, otpWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer readyReader.Close()
+
+	args := []string{"__mux", selectedServer.ServerName}
+	if cfgFile != "" {
+		args = append(args, "--config", cfgFile)
+	}
+	master := exec.Command(self, args...)
+	master.ExtraFiles = []*os.File{otpReader, readyWriter}
+	detachProcess(master)
+	err = master.Start()
+	otpReader.Close()
+	readyWriter.Close()
+	if err != nil {
+		otpWriter.Close()
+		return nil, err
+	}
+	otpWriter.Write(vaultSSHOTPKey)
+	otpWriter.Close()
+	master.Process.Release()
+
+	// the master closes the pipe once it listens, writing why when it can't
+	status, _ := ioutil.ReadAll(readyReader)
+	if len(status) > 0 {
+		return nil, errors.New(string(status))
+	}
+	client := dialMaster(selectedServer.ServerName)
+	if client == nil {
+		return nil, errors.New("the master connection exited")
+	}
+	return client, nil
+}
+
+// startMasterListener logs into the server with the OTP read from otp, listens
+// on its control socket, closes ready and serves sessions there until it is idle or stopped
+func startMasterListener(serverName string, otp io.ReadCloser, ready io.Closer) error {
+	server, found := findServer(serverName)
+	if !found {
+		return fmt.Errorf("no server named %q", serverName)
+	}
+	selectedServer = server
+	key, err := ioutil.ReadAll(otp)
+	otp.Close()
+	if err != nil {
+		return err
+	}
+	vaultSSHOTPKey = Secret(key)
+
+	socket, err := muxSocketPath(serverName)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+	if err := checkMuxDir(filepath.Dir(socket)); err != nil {
+		return err
+	}
+	client, err := dialServer()
+	if err != nil {
+		return err
+	}
+	hostKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		return err
+	}
+	// a socket left by a master that died
+	os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		client.Close()
+		return err
+	}
+	os.Chmod(socket, 0600)
+
+	m := &muxMaster{
+		client: client,
+		config: &ssh.ServerConfig{NoClientAuth: true},
+		stop:   make(chan struct{}),
+	}
+	m.mu.Lock()
+	m.idle = time.AfterFunc(multiplexIdle(), m.idleTimeout)
+	m.mu.Unlock()
+	m.config.AddHostKey(signer)
+	defer startKeepalive(client, serverName)()
+	go m.forwardAgentChannels()
+	go m.serve(listener)
+
+	// hand the terminal back to guttu ssh
+	ready.Close()
+	upstream := make(chan error, 1)
+	go func() { upstream <- client.Wait() }()
+	select {
+	case <-m.stop:
+	case <-upstream:
+	}
+	listener.Close()
+	client.Close()
+	return nil
+}
+
+// muxMaster shares one connection to a server with the sessions connecting to its control socket
+type muxMaster struct {
+	client *ssh.Client
+	config *ssh.ServerConfig
+	stop   chan struct{}
+	idle   *time.Timer
+
+	mu       sync.Mutex
+	active   int
+	stopped  bool
+	agentFor *ssh.ServerConn
+}
+
+// serve accepts the connections of sessions on the control socket
+func (m *muxMaster) serve(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		// counted from here, a session still in its handshake keeps the master open
+		m.mu.Lock()
+		m.active++
+		m.idle.Stop()
+		m.mu.Unlock()
+		go m.handle(conn)
+	}
+}
+
+// idleTimeout stops the master unless a session connected since the timer fired
+func (m *muxMaster) idleTimeout() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.active == 0 {
+		m.stopLocked()
+	}
+}
+
+// stopLocked makes the master close its connection, m.mu must be held
+func (m *muxMaster) stopLocked() {
+	if !m.stopped {
+		m.stopped = true
+		close(m.stop)
+	}
+}
+
+// handle forwards the channels and requests of a session connection to the server
+func (m *muxMaster) handle(conn net.Conn) {
+	defer conn.Close()
+	var sc *ssh.ServerConn
+	defer func() {
+		m.mu.Lock()
+		m.active--
+		if m.active == 0 {
+			m.idle.Reset(multiplexIdle())
+		}
+		if sc != nil && m.agentFor == sc {
+			m.agentFor = nil
+		}
+		m.mu.Unlock()
+	}()
+	sc, chans, reqs, err := ssh.NewServerConn(conn, m.config)
+	if err != nil {
+		return
+	}
+
+	go m.handleGlobalRequests(reqs)
+	for newChannel := range chans {
+		go m.forwardChannel(sc, newChannel)
+	}
+}
+
+// handleGlobalRequests forwards the global requests of a session, such as keepalives, to the server
+func (m *muxMaster) handleGlobalRequests(reqs <-chan *ssh.Request) {
+	for r := range reqs {
+		if r.Type == muxStopRequest {
+			r.Reply(true, nil)
+			m.mu.Lock()
+			m.stopLocked()
+			m.mu.Unlock()
+			continue
+		}
+		ok, payload, err := m.client.SendRequest(r.Type, r.WantReply, r.Payload)
+		if r.WantReply {
+			r.Reply(ok && err == nil, payload)
+		}
+	}
+}
+
+// forwardChannel opens the channel a session asks for on the server and proxies it
+func (m *muxMaster) forwardChannel(sc *ssh.ServerConn, newChannel ssh.NewChannel) {
+	up, upReqs, err := m.client.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		if openErr, ok := err.(*ssh.OpenChannelError); ok {
+			newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+	down, downReqs, err := newChannel.Accept()
+	if err != nil {
+		up.Close()
+		return
+	}
+
+	// agent channels opened by the server go to the last session forwarding its agent
+	reqs := make(chan *ssh.Request)
+	go func() {
+		for r := range downReqs {
+			if r.Type == "auth-agent-req@openssh.com" {
+				m.mu.Lock()
+				m.agentFor = sc
+				m.mu.Unlock()
+			}
+			reqs <- r
+		}
+		close(reqs)
+	}()
+	proxyChannel(down, reqs, up, upReqs)
+}
+
+// forwardAgentChannels proxies the agent channels opened by the server to the session forwarding its agent
+func (m *muxMaster) forwardAgentChannels() {
+	for newChannel := range m.client.HandleChannelOpen("auth-agent@openssh.com") {
+		m.mu.Lock()
+		sc := m.agentFor
+		m.mu.Unlock()
+		if sc == nil {
+			newChannel.Reject(ssh.Prohibited, "no agent forwarded")
+			continue
+		}
+		go func(newChannel ssh.NewChannel) {
+			down, downReqs, err := sc.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				return
+			}
+			up, upReqs, err := newChannel.Accept()
+			if err != nil {
+				down.Close()
+				return
+			}
+			proxyChannel(up, upReqs, down, downReqs)
+		}(newChannel)
+	}
+}
+
+// proxyChannel copies data, stderr and requests between two channels both ways until both are closed
+func proxyChannel(a ssh.Channel, aReqs <-chan *ssh.Request, b ssh.Channel, bReqs <-chan *ssh.Request) {
+	var wg sync.WaitGroup
+	half := func(dst, src ssh.Channel, srcReqs <-chan *ssh.Request) {
+		defer wg.Done()
+		var copies sync.WaitGroup
+		copies.Add(2)
+		go func() {
+			io.Copy(dst, src)
+			dst.CloseWrite()
+			copies.Done()
+		}()
+		go func() {
+			io.Copy(dst.Stderr(), src.Stderr())
+			copies.Done()
+		}()
+		for r := range srcReqs {
+			ok, err := dst.SendRequest(r.Type, r.WantReply, r.Payload)
+			if r.WantReply {
+				r.Reply(ok && err == nil, nil)
+			}
+		}
+		// src is closed, close dst once what src sent is through
+		copies.Wait()
+		dst.Close()
+	}
+	wg.Add(2)
+	go half(a, b, bReqs)
+	go half(b, a, aReqs)
+	wg.Wait()
+}
+
+func init() {
+	rootCmd.AddCommand(muxCmd)
+	rootCmd.AddCommand(muxMasterCmd)
+	muxCmd.AddCommand(muxListCmd)
+	muxCmd.AddCommand(muxStopCmd)
+}
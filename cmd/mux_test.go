@@ -0,0 +1,88 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMuxIdleTimeoutKeepsAcceptedSessions(t *testing.T) {
+	listener, err := net.Listen("unix", t.TempDir()+"/m.sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	saved := cfg.MultiplexIdle
+	cfg.MultiplexIdle = 50 * time.Millisecond
+	defer func() { cfg.MultiplexIdle = saved }()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &muxMaster{config: &ssh.ServerConfig{NoClientAuth: true}, stop: make(chan struct{})}
+	m.config.AddHostKey(signer)
+	m.idle = time.AfterFunc(time.Hour, m.idleTimeout)
+	go m.serve(listener)
+
+	// a session that hasn't finished its handshake when the timer fires
+	conn, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for i := 0; ; i++ {
+		m.mu.Lock()
+		active := m.active
+		m.mu.Unlock()
+		if active == 1 {
+			break
+		}
+		if i == 100 {
+			t.Fatal("the connection was never counted")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	m.idleTimeout()
+	select {
+	case <-m.stop:
+		t.Fatal("the master stopped with a session connected")
+	default:
+	}
+
+	// the timer starts again once the last session is gone
+	conn.Close()
+	select {
+	case <-m.stop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the master didn't stop once idle")
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+const oidcLoginTimeout = 5 * time.Minute
+
+var noBrowser bool
+
+// VaultOIDCAuthURLRequest struct for the payload asking Vault for the OIDC provider URL
+type VaultOIDCAuthURLRequest struct {
+	Role        string `json:"role,omitempty"`
+	RedirectURI string `json:"redirect_uri"`
+	ClientNonce string `json:"client_nonce"`
+}
+
+// VaultOIDCAuthURLResponse struct for the OIDC provider URL to send the user to
+type VaultOIDCAuthURLResponse struct {
+	Data struct {
+		AuthURL string `json:"auth_url"`
+	} `json:"data"`
+}
+
+// VaultJWTLoginRequest struct for the payload of a JWT login
+type VaultJWTLoginRequest struct {
+	Role string `json:"role,omitempty"`
+	JWT  Secret `json:"jwt"`
+}
+
+// oidcCallback struct for the parameters the OIDC provider redirects back with
+type oidcCallback struct {
+	state, code string
+	err         error
+}
+
+// oidcLogin logs in through the OIDC provider: the user opens the provider URL
+// and the provider redirects back to a listener on localhost, whose code is
+// exchanged with Vault for a token
+func oidcLogin(mount string) {
+	// the browser login can't complete without someone at the terminal
+	requireInteractiveLogin("Logging in with OIDC")
+	port := cfg.OIDCCallbackPort
+	if port == 0 {
+		port = 8250
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(port)))
+	if err != nil {
+		logger.Fatal("Unable to listen for the OIDC callback:", err)
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://localhost:%d/oidc/callback", port)
+
+	nonce := make([]byte, 20)
+	if _, err := rand.Read(nonce); err != nil {
+		logger.Fatal(err)
+	}
+	clientNonce := hex.EncodeToString(nonce)
+
+	authURL := VaultOIDCAuthURLResponse{}
+	err = vaultRequest("POST", "auth/"+mount+"/oidc/auth_url", VaultOIDCAuthURLRequest{Role: cfg.AuthRole, RedirectURI: redirectURI, ClientNonce: clientNonce}, &authURL)
+	if err != nil {
+		exitOnVaultError(err)
+	}
+	if authURL.Data.AuthURL == "" {
+		logger.Fatalf("Error: Vault returned no OIDC URL, is %s an allowed redirect URI of the role?", redirectURI)
+	}
+
+	callbacks := make(chan oidcCallback, 1)
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/oidc/callback" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.URL.Query()
+		callback := oidcCallback{state: query.Get("state"), code: query.Get("code")}
+		if e := query.Get("error"); e != "" {
+			callback.err = fmt.Errorf("%s: %s", e, query.Get("error_description"))
+			fmt.Fprintln(w, "Login failed, check your terminal.")
+		} else {
+			fmt.Fprintln(w, "Logged in, you can close this window and return to your terminal.")
+		}
+		select {
+		case callbacks <- callback:
+		default:
+		}
+	})}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	fmt.Fprintln(os.Stderr, "Complete the login in your browser. If it doesn't open, visit:")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "   ", authURL.Data.AuthURL)
+	fmt.Fprintln(os.Stderr)
+	if !noBrowser {
+		openBrowser(authURL.Data.AuthURL)
+	}
+
+	var callback oidcCallback
+	select {
+	case callback = <-callbacks:
+	case <-time.After(oidcLoginTimeout):
+		logger.Fatal("Error: timed out waiting for the OIDC login")
+	}
+	if callback.err != nil {
+		logger.Fatalf("Error: %s", callback.err)
+	}
+
+	query := url.Values{"state": {callback.state}, "code": {callback.code}, "client_nonce": {clientNonce}}
+	loginResponse := VaultAuthLoginResponse{}
+	if err := vaultRequest("GET", "auth/"+mount+"/oidc/callback?"+query.Encode(), nil, &loginResponse); err != nil {
+		exitOnVaultError(err)
+	}
+	finishLogin(loginResponse)
+}
+
+// openBrowser opens the URL in the default browser, failing silently as the URL is printed too
+func openBrowser(u string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	if err := cmd.Start(); err == nil {
+		go cmd.Wait()
+	}
+}
+
+// jwtLogin logs in with a JWT read from jwt_file, as given to CI jobs
+func jwtLogin(mount string) {
+	if cfg.JWTFile == "" {
+		logger.Fatal("Error: set jwt_file to the file holding the JWT")
+	}
+	path, err := homedir.Expand(cfg.JWTFile)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	jwt, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.Fatal("Unable to read the JWT:", err)
+	}
+	defer wipeBytes(jwt)
+	jwt = bytes.TrimSpace(jwt)
+
+	logger.Info("Logging into Vault...")
+	loginResponse := VaultAuthLoginResponse{}
+	if err := vaultRequest("POST", "auth/"+mount+"/login", VaultJWTLoginRequest{Role: cfg.AuthRole, JWT: jwt}, &loginResponse); err != nil {
+		exitOnVaultError(err)
+	}
+	finishLogin(loginResponse)
+}
@@ -0,0 +1,75 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/olekukonko/tablewriter"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var outputFormat string
+
+// outputFormats lists the formats --output takes
+var outputFormats = []string{"table", "plain", "json", "yaml"}
+
+// checkOutputFormat fails on an unknown --output format before the command runs
+func checkOutputFormat() {
+	if !stringInSlice(outputFormat, outputFormats) {
+		logger.Fatalf("Unknown output format %q, use one of %s", outputFormat, strings.Join(outputFormats, ", "))
+	}
+}
+
+// printResult writes the result of a command to stdout in the format asked
+// for with --output: the result itself as JSON or YAML, else its rows as a
+// table or as plain tab separated lines, without the header
+func printResult(result interface{}, header []string, rows [][]string) {
+	switch outputFormat {
+	case "json":
+		out, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Println(string(out))
+	case "yaml":
+		out, err := yaml.Marshal(result)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Print(string(out))
+	case "plain":
+		for _, row := range rows {
+			fmt.Println(strings.Join(row, "\t"))
+		}
+	default:
+		table := tablewriter.NewWriter(os.Stdout)
+		if header != nil {
+			table.SetHeader(header)
+		}
+		table.SetAutoWrapText(false)
+		table.AppendBulk(rows)
+		table.Render()
+	}
+}
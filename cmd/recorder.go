@@ -0,0 +1,240 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+const defaultRecordingsKeep = 100
+
+// AsciicastHeader struct for the first line of an asciicast v2 recording
+type AsciicastHeader struct {
+	Version   int                `json:"version"`
+	Width     int                `json:"width"`
+	Height    int                `json:"height"`
+	Timestamp int64              `json:"timestamp"`
+	Title     string             `json:"title,omitempty"`
+	Env       map[string]string  `json:"env,omitempty"`
+	Guttu     *RecordingMetadata `json:"guttu,omitempty"`
+}
+
+// RecordingMetadata struct for who recorded a session, where and with which OTP
+type RecordingMetadata struct {
+	User          string `json:"user"`
+	ServerName    string `json:"server_name"`
+	IP            string `json:"ip"`
+	LoginUsername string `json:"login_username"`
+	VaultRole     string `json:"vault_role"`
+	LeaseID       string `json:"lease_id,omitempty"`
+}
+
+// sessionRecorder writes the output of a session to an asciicast v2 file
+type sessionRecorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	started time.Time
+	// the start of a multi-byte character split across writes
+	partial []byte
+}
+
+// recordSession reports whether the session on the selected server has to be
+// recorded: when asked with --record, or by the server or one of its tags
+func recordSession() bool {
+	if recordFlag || selectedServer.Record {
+		return true
+	}
+	for _, tag := range selectedServer.Tags {
+		if stringInSlice(tag, cfg.RecordTags) {
+			return true
+		}
+	}
+	return false
+}
+
+// recordingsDir returns where recordings are stored, ~/.guttu-recordings unless configured
+func recordingsDir() (string, error) {
+	if cfg.RecordingsDir != "" {
+		return homedir.Expand(cfg.RecordingsDir)
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".guttu-recordings"), nil
+}
+
+// newSessionRecorder starts a recording of a session on the selected server
+// in a terminal of the given size, then removes the oldest recordings
+func newSessionRecorder(width, height int) (*sessionRecorder, error) {
+	dir, err := recordingsDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	started := time.Now()
+	prefix := started.Format("20060102-150405")
+	server := recordingFileName(selectedServer.ServerName)
+	var file *os.File
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("%s-%s.cast", prefix, server)
+		if n > 1 {
+			// another session on the server started the same second
+			name = fmt.Sprintf("%s-%s-%d.cast", prefix, server, n)
+		}
+		file, err = os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	header := AsciicastHeader{
+		Version:   2,
+		Width:     width,
+		Height:    height,
+		Timestamp: started.Unix(),
+		Title:     selectedServer.LoginUsername + "@" + selectedServer.ServerName,
+		Env:       map[string]string{"TERM": os.Getenv("TERM"), "SHELL": os.Getenv("SHELL")},
+		Guttu: &RecordingMetadata{
+			User:          localUsername(),
+			ServerName:    selectedServer.ServerName,
+			IP:            selectedServer.IP,
+			LoginUsername: selectedServer.LoginUsername,
+			VaultRole:     selectedServer.VaultRole,
+			LeaseID:       vaultSSHOTPLeaseID,
+		},
+	}
+	if err := json.NewEncoder(file).Encode(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	rotateRecordings(dir)
+	return &sessionRecorder{file: file, started: started}, nil
+}
+
+// Write records output of the session, it never fails so it can be used in an io.MultiWriter
+func (r *sessionRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := append(r.partial, p...)
+	// keep an incomplete character at the end for the next write
+	end := len(data)
+	for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+		if utf8.RuneStart(data[len(data)-i]) {
+			if !utf8.FullRune(data[len(data)-i:]) {
+				end = len(data) - i
+			}
+			break
+		}
+	}
+	r.partial = append([]byte{}, data[end:]...)
+	if end > 0 {
+		r.event("o", string(data[:end]))
+	}
+	return len(p), nil
+}
+
+// Resize records a change of the terminal size
+func (r *sessionRecorder) Resize(width, height int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// event appends an event line, the caller holds the lock
+func (r *sessionRecorder) event(kind, data string) {
+	line, _ := json.Marshal([]interface{}{time.Since(r.started).Seconds(), kind, data})
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		// compliance needs to know about the gap, but the session goes on
+		logger.Warn("Unable to record the session:", err)
+	}
+}
+
+// Close ends the recording
+func (r *sessionRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.partial) > 0 {
+		r.event("o", string(r.partial))
+	}
+	return r.file.Close()
+}
+
+// recordingFileName returns the server name as it goes in the file name of a
+// recording, keeping it in the recordings directory whatever the name holds
+func recordingFileName(serverName string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, filepath.Base(serverName))
+}
+
+// rotateRecordings removes the oldest recordings once there are more than recordings_keep
+func rotateRecordings(dir string) {
+	keep := cfg.RecordingsKeep
+	if keep == 0 {
+		keep = defaultRecordingsKeep
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.cast"))
+	if err != nil || len(files) <= keep {
+		return
+	}
+	modTimes := map[string]time.Time{}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	// oldest first, by when the sessions ended
+	sort.Slice(files, func(i, j int) bool { return modTimes[files[i]].Before(modTimes[files[j]]) })
+	for _, file := range files[:len(files)-keep] {
+		if err := os.Remove(file); err != nil {
+			logger.Warn("Unable to remove an old recording:", err)
+		}
+	}
+}
+
+// localUsername returns the name of the local user running guttu
+func localUsername() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return strings.TrimSpace(os.Getenv("USER"))
+}
@@ -0,0 +1,110 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"time"
+)
+
+const (
+	renewMinBackoff = 5 * time.Second
+	renewMaxBackoff = time.Minute
+)
+
+// tokenRenewer keeps the Vault token alive during long sessions by renewing
+// it before it expires, until its max TTL is reached
+type tokenRenewer struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startTokenRenewer starts renewing the token in use in the background. It
+// returns nil when the token can't be renewed, never expires or belongs to Vault Agent.
+func startTokenRenewer() *tokenRenewer {
+	if agentInUse() {
+		// the agent renews its own token
+		return nil
+	}
+	lookup, err := lookupToken()
+	if err != nil || !lookup.Data.Renewable || lookup.Data.TTL <= 0 {
+		return nil
+	}
+	r := &tokenRenewer{stop: make(chan struct{}), done: make(chan struct{})}
+	go r.run(time.Duration(lookup.Data.TTL) * time.Second)
+	return r
+}
+
+// Stop stops renewing the token and waits for a renewal in progress
+func (r *tokenRenewer) Stop() {
+	if r == nil {
+		return
+	}
+	close(r.stop)
+	<-r.done
+}
+
+func (r *tokenRenewer) run(ttl time.Duration) {
+	defer close(r.done)
+	expires := time.Now().Add(ttl)
+	backoff := renewMinBackoff
+	// renew once two thirds of the TTL have passed
+	wait := ttl * 2 / 3
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-time.After(wait):
+		}
+
+		renewed := VaultAuthLoginResponse{}
+		err := vaultRequest("POST", "auth/token/renew-self", nil, &renewed)
+		remaining := time.Until(expires)
+		if err != nil {
+			if remaining <= 0 {
+				logger.Warn("The Vault token expired, run guttu login to get a new one")
+				return
+			}
+			wait = backoff
+			if wait > remaining/2 {
+				wait = remaining / 2
+			}
+			logger.Warnf("Unable to renew the Vault token, retrying in %s: %v", wait.Round(time.Second), err)
+			backoff *= 2
+			if backoff > renewMaxBackoff {
+				backoff = renewMaxBackoff
+			}
+			continue
+		}
+		backoff = renewMinBackoff
+
+		renewedTTL := time.Duration(renewed.Auth.LeaseDuration) * time.Second
+		if renewedTTL < ttl || !renewed.Auth.Renewable {
+			// Vault capped the renewal, the token has reached its max TTL
+			logger.Warn("The Vault token reaches its max TTL in", renewedTTL.Round(time.Second), "- run guttu login to get a new one")
+			return
+		}
+		ttl = renewedTTL
+		expires = time.Now().Add(ttl)
+		logger.Debug("Renewed the Vault token for", ttl)
+		wait = ttl * 2 / 3
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var replaySpeed float64
+var replayMaxWait time.Duration
+
+// replayCmd represents the replay command
+var replayCmd = &cobra.Command{
+	Use:   "replay <recording>",
+	Short: "Play back a recorded session",
+	Long: `Play back a session recorded by guttu ssh --record, or any asciicast v2 file,
+in the terminal with its original timing.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if replaySpeed <= 0 {
+			logger.Fatal("--speed must be greater than 0")
+		}
+		file, err := os.Open(args[0])
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer file.Close()
+		if err := replayRecording(file, os.Stdout); err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+// replayRecording writes the output events of an asciicast v2 recording to out as they were timed
+func replayRecording(in io.Reader, out io.Writer) error {
+	reader := bufio.NewReader(in)
+	line, err := reader.ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return fmt.Errorf("not an asciicast recording: %s", err)
+	}
+	header := AsciicastHeader{}
+	if err := json.Unmarshal(line, &header); err != nil || header.Version != 2 {
+		return fmt.Errorf("not an asciicast v2 recording")
+	}
+	if m := header.Guttu; m != nil {
+		fmt.Fprintf(os.Stderr, "Session of %s on %s (%s@%s, role %s) recorded %s\n",
+			m.User, m.ServerName, m.LoginUsername, m.IP, m.VaultRole, time.Unix(header.Timestamp, 0).Format(time.RFC1123))
+	}
+
+	previous := 0.0
+	for lineNumber := 2; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var event []interface{}
+			if jsonErr := json.Unmarshal(line, &event); jsonErr != nil || len(event) != 3 {
+				return fmt.Errorf("line %d: malformed event", lineNumber)
+			}
+			at, _ := event[0].(float64)
+			kind, _ := event[1].(string)
+			data, _ := event[2].(string)
+
+			wait := time.Duration((at - previous) / replaySpeed * float64(time.Second))
+			if replayMaxWait > 0 && wait > replayMaxWait {
+				wait = replayMaxWait
+			}
+			time.Sleep(wait)
+			previous = at
+			// input and resize events can't be played back in this terminal
+			if kind == "o" {
+				io.WriteString(out, data)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(replayCmd)
+
+	replayCmd.Flags().Float64VarP(&replaySpeed, "speed", "s", 1, "play back faster (2) or slower (0.5)")
+	replayCmd.Flags().DurationVar(&replayMaxWait, "max-wait", 0, "shorten pauses longer than this, such as 2s")
+}
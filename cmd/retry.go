@@ -0,0 +1,175 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// exit statuses telling why a Vault request failed, after sysexits.h
+const (
+	exitAuthFailed       = 67 // EX_NOUSER, the login was refused
+	exitVaultUnavailable = 69 // EX_UNAVAILABLE, Vault couldn't be reached or isn't serving
+	exitPermissionDenied = 77 // EX_NOPERM, the token isn't allowed to do this
+)
+
+const (
+	defaultVaultTimeout     = 60 * time.Second
+	defaultVaultMaxAttempts = 4
+	vaultBackoffBase        = 500 * time.Millisecond
+	vaultBackoffMax         = 10 * time.Second
+)
+
+// vaultTimeout returns how long a single Vault request may take
+func vaultTimeout() time.Duration {
+	if cfg.VaultTimeout > 0 {
+		return cfg.VaultTimeout
+	}
+	return defaultVaultTimeout
+}
+
+// vaultMaxAttempts returns how many times all Vault addresses are tried before giving up
+func vaultMaxAttempts() int {
+	if cfg.VaultMaxAttempts > 0 {
+		return cfg.VaultMaxAttempts
+	}
+	return defaultVaultMaxAttempts
+}
+
+// vaultBackoff returns how long to wait before the next attempt of a Vault request
+func vaultBackoff(attempt int) time.Duration {
+	return backoff(attempt, vaultBackoffBase, vaultBackoffMax)
+}
+
+// backoff returns how long to wait before the next attempt, doubling from base
+// up to max with jitter so that many clients don't retry in lockstep
+func backoff(attempt int, base, max time.Duration) time.Duration {
+	wait := base << uint(attempt-1)
+	if wait > max || wait <= 0 {
+		wait = max
+	}
+	jitterMu.Lock()
+	defer jitterMu.Unlock()
+	return wait/2 + time.Duration(jitter.Int63n(int64(wait/2)+1))
+}
+
+// jitter randomizes backoffs, the renewer and requests share it
+var (
+	jitter   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	jitterMu sync.Mutex
+)
+
+// retryableVaultRequest reports whether a failed request can be sent again.
+// Any request Vault didn't serve is, while requests with side effects that
+// failed on the way, such as issuing an OTP or unwrapping a single use token,
+// are not as the first attempt may have gone through.
+func retryableVaultRequest(method, path string, err error) bool {
+	switch {
+	case vaultNotServed(err):
+		return true
+	case method == "GET", method == "HEAD", method == "PUT", method == "DELETE", method == "LIST":
+		return unavailableVaultError(err)
+	case isLoginPath(path) && path != "sys/wrapping/unwrap":
+		// logins only hand out a new token
+		return unavailableVaultError(err)
+	}
+	return false
+}
+
+// vaultNotServed reports whether Vault didn't act on a request: it couldn't be
+// sent, or Vault answered it isn't ready (412), is a standby or rate limiting (429)
+// or is sealed (503)
+func vaultNotServed(err error) bool {
+	if vaultErr, ok := err.(*VaultErrorResponse); ok {
+		code := vaultErr.StatusCode
+		return code == 412 || code == 429 || code == 503
+	}
+	return requestNotSent(err)
+}
+
+// requestNotSent reports whether a request failed before it was sent, while connecting to Vault
+func requestNotSent(err error) bool {
+	urlErr, ok := err.(*url.Error)
+	if !ok {
+		return false
+	}
+	var opErr *net.OpError
+	return errors.As(urlErr.Err, &opErr) && opErr.Op == "dial"
+}
+
+// unavailableVaultError reports whether a request failed because Vault isn't serving:
+// connection errors and timeouts, and Vault answering it isn't ready (412),
+// is rate limiting or a standby (429) or is sealed or failing (5xx)
+func unavailableVaultError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if vaultErr, ok := err.(*VaultErrorResponse); ok {
+		code := vaultErr.StatusCode
+		return code == 412 || code == 429 || code >= 500
+	}
+	_, ok := err.(*url.Error)
+	return ok
+}
+
+// tokenRejected reports whether Vault refused the token of a request, which
+// it does when the token expired or was revoked as for missing permissions
+func tokenRejected(err error) bool {
+	vaultErr, ok := err.(*VaultErrorResponse)
+	return ok && (vaultErr.StatusCode == 401 || vaultErr.StatusCode == 403)
+}
+
+// isLoginPath reports whether a Vault API path checks credentials
+func isLoginPath(path string) bool {
+	return strings.HasPrefix(path, "auth/") || path == "sys/mfa/validate" || path == "sys/wrapping/unwrap"
+}
+
+// vaultExitCode returns the exit status for a failed Vault request
+func vaultExitCode(err error) int {
+	if unavailableVaultError(err) {
+		return exitVaultUnavailable
+	}
+	if vaultErr, ok := err.(*VaultErrorResponse); ok {
+		switch {
+		case isLoginPath(vaultErr.Path) && (vaultErr.StatusCode == 400 || vaultErr.StatusCode == 401 || vaultErr.StatusCode == 403):
+			return exitAuthFailed
+		case vaultErr.StatusCode == 403:
+			return exitPermissionDenied
+		}
+	}
+	return 1
+}
+
+// exitOnVaultError prints the error of a failed Vault request and exits with its status
+func exitOnVaultError(err error) {
+	logger.Errorf("Error: %s", err)
+	e := auditServerEvent(auditError)
+	e.VaultAddress, e.Error = vaultAddress(), err.Error()
+	audit(e)
+	os.Exit(vaultExitCode(err))
+}
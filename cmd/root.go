@@ -21,29 +21,89 @@
 package cmd
 
 import (
+	"bytes"
 	"fmt"
 	"os"
+	"time"
 
-	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	yaml "gopkg.in/yaml.v2"
 )
 
 var cfgFile string
 
+// GuttuServerStruct struct for holding a single server entry of the configuration
+type GuttuServerStruct struct {
+	IP            string               `mapstructure:"ip" json:"ip" yaml:"ip"`
+	ServerName    string               `mapstructure:"server_name" json:"server_name" yaml:"server_name"`
+	LoginUsername string               `mapstructure:"login_username" json:"login_username" yaml:"login_username"`
+	VaultRole     string               `mapstructure:"vault_role" json:"vault_role" yaml:"vault_role"`
+	Port          int                  `mapstructure:"port" json:"port,omitempty" yaml:"port,omitempty"`
+	ProxyJump     string               `mapstructure:"proxy_jump" json:"proxy_jump,omitempty" yaml:"proxy_jump,omitempty"`
+	Favourite     bool                 `mapstructure:"favourite" json:"favourite" yaml:"favourite,omitempty"`
+	Tags          []string             `mapstructure:"tags" json:"tags,omitempty" yaml:"tags,omitempty"`
+	Record        bool                 `mapstructure:"record" json:"record,omitempty" yaml:"record,omitempty"`
+	ForwardAgent  bool                 `mapstructure:"forward_agent" json:"forward_agent,omitempty" yaml:"forward_agent,omitempty"`
+	Multiplex     bool                 `mapstructure:"multiplex" json:"multiplex,omitempty" yaml:"multiplex,omitempty"`
+	SSH           *SSHAlgorithmsStruct `mapstructure:"ssh" json:"ssh,omitempty" yaml:"ssh,omitempty"`
+	SendEnv       []string             `mapstructure:"send_env" json:"send_env,omitempty" yaml:"send_env,omitempty"`
+	SetEnv        []string             `mapstructure:"set_env" json:"set_env,omitempty" yaml:"set_env,omitempty"`
+	RemoteCommand string               `mapstructure:"remote_command" json:"remote_command,omitempty" yaml:"remote_command,omitempty"`
+}
+
+// SSHAlgorithmsStruct struct for the SSH algorithms allowed with servers, the defaults when empty
+type SSHAlgorithmsStruct struct {
+	Ciphers           []string `mapstructure:"ciphers" json:"ciphers,omitempty" yaml:"ciphers,omitempty"`
+	KEX               []string `mapstructure:"kex" json:"kex,omitempty" yaml:"kex,omitempty"`
+	MACs              []string `mapstructure:"macs" json:"macs,omitempty" yaml:"macs,omitempty"`
+	HostKeyAlgorithms []string `mapstructure:"host_key_algorithms" json:"host_key_algorithms,omitempty" yaml:"host_key_algorithms,omitempty"`
+}
+
 // GuttuConfigStruct struct for holding configuration
 type GuttuConfigStruct struct {
-	VaultAddress string `mapstructure:"vault_address"`
-	Servers      []struct {
-		IP            string `mapstructure:"ip"`
-		ServerName    string `mapstructure:"server_name"`
-		LoginUsername string `mapstructure:"login_username"`
-		VaultRole     string `mapstructure:"vault_role"`
-	} `mapstructure:"servers"`
+	VaultAddress      string               `mapstructure:"vault_address"`
+	VaultAddresses    []string             `mapstructure:"vault_addresses"`
+	VaultTimeout      time.Duration        `mapstructure:"vault_timeout"`
+	VaultMaxAttempts  int                  `mapstructure:"vault_max_attempts"`
+	AgentAddress      string               `mapstructure:"agent_address"`
+	HistoryFile       string               `mapstructure:"history_file"`
+	SSHPassSecret     string               `mapstructure:"sshpass_secret"`
+	RevokeLease       bool                 `mapstructure:"revoke_lease"`
+	KeepToken         bool                 `mapstructure:"keep_token"`
+	AuthMethod        string               `mapstructure:"auth_method"`
+	AuthMount         string               `mapstructure:"auth_mount"`
+	AuthRole          string               `mapstructure:"auth_role"`
+	JWTFile           string               `mapstructure:"jwt_file"`
+	RoleIDFile        string               `mapstructure:"role_id_file"`
+	SecretIDFile      string               `mapstructure:"secret_id_file"`
+	SecretIDWrapped   bool                 `mapstructure:"secret_id_wrapped"`
+	TokenFile         string               `mapstructure:"token_file"`
+	OIDCCallbackPort  int                  `mapstructure:"oidc_callback_port"`
+	TokenStore        string               `mapstructure:"token_store"`
+	AuditLog          string               `mapstructure:"audit_log"`
+	SSHBackend        string               `mapstructure:"ssh_backend"`
+	RecordTags        []string             `mapstructure:"record_tags"`
+	RecordingsDir     string               `mapstructure:"recordings_dir"`
+	RecordingsKeep    int                  `mapstructure:"recordings_keep"`
+	Multiplex         bool                 `mapstructure:"multiplex"`
+	MultiplexIdle     time.Duration        `mapstructure:"multiplex_idle"`
+	KeepaliveInterval time.Duration        `mapstructure:"keepalive_interval"`
+	KeepaliveCountMax int                  `mapstructure:"keepalive_count_max"`
+	SSH               *SSHAlgorithmsStruct `mapstructure:"ssh"`
+	Servers           []GuttuServerStruct  `mapstructure:"servers"`
 }
 
 var cfg GuttuConfigStruct
 
+// config layers found, every config file loaded (includes first), where each
+// value came from and the problems hit while loading them
+var cfgLayers []string
+var cfgFiles []string
+var cfgOrigins map[string]configOrigin
+var cfgLoadProblems []ConfigProblem
+var cfgUnmarshalErr error
+
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
 	Version: "0.0.1",
@@ -53,13 +113,18 @@ var rootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	//	Run: func(cmd *cobra.Command, args []string) { },
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		setupLogger()
+		checkOutputFormat()
+		checkConfig(cmd)
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
@@ -70,33 +135,46 @@ func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.guttu.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file, loaded over /etc/guttu/config.yaml, $XDG_CONFIG_HOME/guttu/config.yaml, $HOME/.guttu.yaml and ./.guttu.yaml")
+	rootCmd.PersistentFlags().CountVarP(&verbosity, "verbose", "v", "log more, -vv logs every Vault request")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "only log errors")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", "text", "log format: text or json")
+	rootCmd.PersistentFlags().BoolVar(&debugHTTP, "debug-http", false, "log Vault requests and responses, with tokens, passwords and keys redacted")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", "table", "output format of results: table, plain, json or yaml")
 
 }
 
-// initConfig reads in config file and ENV variables if set.
+// initConfig reads in the config files and ENV variables if set.
 func initConfig() {
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
-	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
-		}
-
-		// Search config in home directory with name ".guttu" (without extension).
-		viper.SetConfigName(".guttu")
-		viper.AddConfigPath(".")  // look for config in the working directory
-		viper.AddConfigPath(home) // then look form home directory
+	loader := newConfigLoader()
+	cfgLayers = configLayerFiles()
+	for _, file := range cfgLayers {
+		loader.load(file, 0)
 	}
+	cfgFiles = loader.files
+	cfgOrigins = loader.origins
+	cfgLoadProblems = loader.problems
 
 	viper.AutomaticEnv() // read in environment variables that match
 
-	// If a config file is found, read it in.
-	if err := viper.ReadInConfig(); err == nil {
-		viper.Unmarshal(&cfg)
+	// Hand the merged files over to viper as a single config.
+	merged, err := yaml.Marshal(loader.merged())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	viper.SetConfigType("yaml")
+	if err := viper.ReadConfig(bytes.NewReader(merged)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	cfgUnmarshalErr = viper.Unmarshal(&cfg)
+}
+
+// configFileUsed returns the config file with the highest precedence, "" when none was found
+func configFileUsed() string {
+	if len(cfgLayers) == 0 {
+		return ""
 	}
+	return cfgLayers[len(cfgLayers)-1]
 }
@@ -0,0 +1,272 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var serverFlags GuttuServerStruct
+
+// serversCmd represents the servers command
+var serversCmd = &cobra.Command{
+	Use:   "servers",
+	Short: "Manage the servers in your config file",
+	Long:  `List, add, remove, edit and show the servers of your config file without editing the YAML by hand.`,
+}
+
+var serversListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the configured servers",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		printServers(cfg.Servers)
+	},
+}
+
+// lsCmd represents the ls command, a shortcut for servers list
+var lsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List the configured servers, same as servers list",
+	Args:  cobra.NoArgs,
+	Run:   serversListCmd.Run,
+}
+
+var serversShowCmd = &cobra.Command{
+	Use:   "show <server name>",
+	Short: "Show a single configured server",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		s, ok := findServer(args[0])
+		if !ok {
+			logger.Fatalf("No server named %q in config file", args[0])
+		}
+		printServers([]GuttuServerStruct{s})
+	},
+}
+
+var serversAddCmd = &cobra.Command{
+	Use:   "add",
+	Short: "Add a server to the config file",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		f := loadServersForEdit()
+		f.add(serverFlags)
+		saveServers(f)
+		fmt.Println("Added", serverFlags.ServerName, "to", f.path)
+	},
+}
+
+var serversRemoveCmd = &cobra.Command{
+	Use:   "remove <server name>",
+	Short: "Remove a server from the config file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f := loadServersForEdit()
+		f.remove(serverIndex(f, args[0]))
+		saveServers(f)
+		fmt.Println("Removed", args[0], "from", f.path)
+	},
+}
+
+var serversEditCmd = &cobra.Command{
+	Use:   "edit <server name>",
+	Short: "Change the settings of a server in the config file",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		f := loadServersForEdit()
+		s := &f.items[serverIndex(f, args[0])].server
+		flags := cmd.Flags()
+		if flags.Changed("name") {
+			s.ServerName = serverFlags.ServerName
+		}
+		if flags.Changed("ip") {
+			s.IP = serverFlags.IP
+		}
+		if flags.Changed("user") {
+			s.LoginUsername = serverFlags.LoginUsername
+		}
+		if flags.Changed("role") {
+			s.VaultRole = serverFlags.VaultRole
+		}
+		if flags.Changed("port") {
+			s.Port = serverFlags.Port
+		}
+		if flags.Changed("proxy-jump") {
+			s.ProxyJump = serverFlags.ProxyJump
+		}
+		if flags.Changed("favourite") {
+			s.Favourite = serverFlags.Favourite
+		}
+		if flags.Changed("tag") {
+			s.Tags = serverFlags.Tags
+		}
+		if flags.Changed("record") {
+			s.Record = serverFlags.Record
+		}
+		if flags.Changed("forward-agent") {
+			s.ForwardAgent = serverFlags.ForwardAgent
+		}
+		if flags.Changed("mux") {
+			s.Multiplex = serverFlags.Multiplex
+		}
+		if flags.Changed("send-env") {
+			s.SendEnv = serverFlags.SendEnv
+		}
+		if flags.Changed("set-env") {
+			s.SetEnv = serverFlags.SetEnv
+		}
+		if flags.Changed("remote-command") {
+			s.RemoteCommand = serverFlags.RemoteCommand
+		}
+		saveServers(f)
+		fmt.Println("Updated", s.ServerName, "in", f.path)
+	},
+}
+
+// printServers writes the servers to stdout in the format asked for with --output
+func printServers(servers []GuttuServerStruct) {
+	rows := make([][]string, 0, len(servers))
+	for _, s := range servers {
+		rows = append(rows, []string{s.ServerName, s.IP, strconv.Itoa(serverPort(s)), s.LoginUsername, s.VaultRole, strconv.FormatBool(s.Favourite), strings.Join(s.Tags, ",")})
+	}
+	printResult(servers, []string{"Server Name", "IP", "Port", "Login Username", "Vault Role", "Favourite", "Tags"}, rows)
+}
+
+// serversConfigFile returns the config file the servers commands write to:
+// the --config file, else ./.guttu.yaml when it exists, else ~/.guttu.yaml
+func serversConfigFile() string {
+	if cfgFile != "" {
+		return cfgFile
+	}
+	if _, err := os.Stat(".guttu.yaml"); err == nil {
+		return ".guttu.yaml"
+	}
+	home, err := homedir.Dir()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return filepath.Join(home, ".guttu.yaml")
+}
+
+// loadServersForEdit reads the servers of the config file, which keeps every
+// other setting and comment as it is in the file when saved
+func loadServersForEdit() *serversFile {
+	file := serversConfigFile()
+	if ext := filepath.Ext(file); ext != ".yaml" && ext != ".yml" {
+		logger.Fatalf("The servers commands only edit YAML config files, not %s", file)
+	}
+	f, err := readServersFile(file)
+	if err != nil {
+		logger.Fatal("Unable to read config file:", err)
+	}
+	return f
+}
+
+// saveServers validates the servers and writes them back to the config file
+func saveServers(f *serversFile) {
+	if err := validateServers(f.servers()); err != nil {
+		logger.Fatal(err)
+	}
+	if err := f.save(); err != nil {
+		logger.Fatal("Unable to write config file:", err)
+	}
+}
+
+// serverToMap converts a server into the map written to the config file, going
+// through its yaml tags so empty optional settings are left out
+func serverToMap(s GuttuServerStruct) map[string]interface{} {
+	out, err := yaml.Marshal(s)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	m := map[string]interface{}{}
+	if err := yaml.Unmarshal(out, &m); err != nil {
+		logger.Fatal(err)
+	}
+	return m
+}
+
+// serverIndex returns the position of the named server, exiting when it doesn't exist
+func serverIndex(f *serversFile, name string) int {
+	i := f.index(name)
+	if i == -1 {
+		logger.Fatalf("No server named %q in config file", name)
+	}
+	return i
+}
+
+// validateServers checks the fields of every server and that no server name is used twice
+func validateServers(servers []GuttuServerStruct) error {
+	seen := map[string]bool{}
+	for _, s := range servers {
+		switch {
+		case s.ServerName == "":
+			return fmt.Errorf("Server with IP %q has no server_name", s.IP)
+		case seen[s.ServerName]:
+			return fmt.Errorf("Server name %q is used more than once", s.ServerName)
+		case net.ParseIP(s.IP) == nil:
+			return fmt.Errorf("Server %q has an invalid IP %q", s.ServerName, s.IP)
+		case s.LoginUsername == "":
+			return fmt.Errorf("Server %q has no login_username", s.ServerName)
+		case s.VaultRole == "":
+			return fmt.Errorf("Server %q has no vault_role", s.ServerName)
+		case s.Port < 0 || s.Port > 65535:
+			return fmt.Errorf("Server %q has an invalid port %d", s.ServerName, s.Port)
+		}
+		seen[s.ServerName] = true
+	}
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(serversCmd, lsCmd)
+	serversCmd.AddCommand(serversListCmd, serversShowCmd, serversAddCmd, serversRemoveCmd, serversEditCmd)
+
+	for _, c := range []*cobra.Command{serversAddCmd, serversEditCmd} {
+		c.Flags().StringVar(&serverFlags.ServerName, "name", "", "server name")
+		c.Flags().StringVar(&serverFlags.IP, "ip", "", "server IP address")
+		c.Flags().StringVar(&serverFlags.LoginUsername, "user", "", "user name to login with")
+		c.Flags().StringVar(&serverFlags.VaultRole, "role", "", "Vault SSH role used to generate the OTP")
+		c.Flags().IntVar(&serverFlags.Port, "port", 0, "SSH port, 22 when not set")
+		c.Flags().StringVar(&serverFlags.ProxyJump, "proxy-jump", "", "jump host to connect through, as for ssh -J")
+		c.Flags().BoolVar(&serverFlags.Favourite, "favourite", false, "pin the server to the top of the server list")
+		c.Flags().StringSliceVar(&serverFlags.Tags, "tag", nil, "tag the server, repeat for more tags")
+		c.Flags().BoolVar(&serverFlags.Record, "record", false, "always record sessions on the server")
+		c.Flags().BoolVar(&serverFlags.ForwardAgent, "forward-agent", false, "always forward your SSH agent to the server")
+		c.Flags().BoolVar(&serverFlags.Multiplex, "mux", false, "always share a master connection between the sessions on the server")
+		c.Flags().StringSliceVar(&serverFlags.SendEnv, "send-env", nil, "local variables sent to the server, patterns like LC_* allowed, repeat for more")
+		c.Flags().StringArrayVar(&serverFlags.SetEnv, "set-env", nil, "NAME=value variable set on the server, repeat for more")
+		c.Flags().StringVar(&serverFlags.RemoteCommand, "remote-command", "", "command run instead of the login shell, such as \"sudo -i\"")
+	}
+	for _, name := range []string{"name", "ip", "user", "role"} {
+		serversAddCmd.MarkFlagRequired(name)
+	}
+}
@@ -21,124 +21,242 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
-	"net/http"
+	"net"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
 
-	"github.com/howeyc/gopass"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
-	"github.com/spf13/viper"
 )
 
-// VaultErrorResponse struct for error response from vault API
-type VaultErrorResponse struct {
-	Errors []string
-}
-
-// VaultAuthLoginResponse struct for success response from vault API after logging in
-type VaultAuthLoginResponse struct {
-	RequestID     string      `json:"request_id"`
-	LeaseID       string      `json:"lease_id"`
-	Renewable     bool        `json:"renewable"`
-	LeaseDuration int         `json:"lease_duration"`
-	Data          interface{} `json:"data"`
-	WrapInfo      interface{} `json:"wrap_info"`
-	Warnings      interface{} `json:"warnings"`
-	Auth          struct {
-		ClientToken   string   `json:"client_token"`
-		Accessor      string   `json:"accessor"`
-		Policies      []string `json:"policies"`
-		TokenPolicies []string `json:"token_policies"`
-		Metadata      struct {
-			Username string `json:"username"`
-		} `json:"metadata"`
-		LeaseDuration int    `json:"lease_duration"`
-		Renewable     bool   `json:"renewable"`
-		EntityID      string `json:"entity_id"`
-	} `json:"auth"`
-}
-
-// VaultSSHOTPResponse struct for valid otp response
-type VaultSSHOTPResponse struct {
-	LeaseID       string `json:"lease_id"`
-	Renewable     bool   `json:"renewable"`
-	LeaseDuration int    `json:"lease_duration"`
-	Data          struct {
-		IP       string `json:"ip"`
-		Key      string `json:"key"`
-		KeyType  string `json:"key_type"`
-		Port     int    `json:"port"`
-		Username string `json:"username"`
-	} `json:"data"`
-	Warnings interface{} `json:"warnings"`
-	Auth     interface{} `json:"auth"`
-}
-
-var selectedServerNumber int
-var vaultUserToken string
-var vaultSSHOTPKey string
+var selectedServer GuttuServerStruct
+var vaultSSHOTPKey Secret
+var vaultSSHOTPLeaseID string
+var revokeLeaseFlag bool
+var keepTokenFlag bool
+var sshBackendFlag string
+var recordFlag bool
 
 // sshCmd represents the ssh command
 var sshCmd = &cobra.Command{
-	Use:   "ssh",
+	Use:   "ssh [server name | -]",
 	Short: "Login to a Server with Vault OTP",
-	Long:  `Login to the servers listed in your config file through SSH OTPs generated by HashiCorp Vault.`,
+	Long: `Login to the servers listed in your config file through SSH OTPs generated by HashiCorp Vault.
+
+Pass a server name to skip the server selection, or "-" to login to the last server you used.`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		log.Println("Using config file:", viper.ConfigFileUsed())
-		log.Println("Using Vault Address:", cfg.VaultAddress)
-		showVaultLoginPrompt()
-		showServerSelection()
-		generateVaultCredentials()
-		// loginToServer()
-		loginToServerWithSSHPass()
+		logger.Debug("Using config files:", strings.Join(cfgFiles, ", "))
+		if !agentInUse() {
+			logger.Debug("Using Vault Address:", cfg.VaultAddress)
+		}
+		if len(cfg.Servers) == 0 {
+			logger.Fatal("No servers found in config file, add one with `guttu servers add`")
+		}
+		if len(args) == 1 {
+			selectServerByArg(args[0])
+		}
+		if selectedServer.ServerName == "" {
+			showServerSelection()
+		}
+		native := useNativeSSH()
+		var sshpassBinary string
+		if !native {
+			// before any OTP is issued, it would be left unused
+			var err error
+			if sshpassBinary, err = exec.LookPath("sshpass"); err != nil {
+				logger.Fatal("sshpass not found in PATH, install it or use --backend native")
+			}
+		}
+
+		// a master connection to the server needs neither Vault nor a new OTP
+		var master *ssh.Client
+		if native && multiplexSession() {
+			master = dialMaster(selectedServer.ServerName)
+		}
+		var renewer *tokenRenewer
+		if master == nil {
+			ensureVaultToken()
+			generateVaultCredentials()
+			renewer = startTokenRenewer()
+		} else {
+			logger.Info("Reusing the master connection to", selectedServer.ServerName)
+		}
+
+		started := time.Now()
+		connect := auditServerEvent(auditConnect)
+		switch {
+		case master != nil:
+			connect.Backend = "mux"
+		case native:
+			connect.Backend = "native"
+		default:
+			connect.Backend = "sshpass"
+		}
+		connect.Command = remoteCommand()
+		audit(connect)
+		var exitStatus int
+		var err error
+		if native {
+			exitStatus, err = loginToServer(master)
+		} else {
+			exitStatus, err = loginToServerWithSSHPass(sshpassBinary)
+		}
+		if err != nil {
+			logger.Error("Error:", err)
+			e := auditServerEvent(auditError)
+			e.Error = err.Error()
+			audit(e)
+			// the OTP may never have been used, don't leave it valid
+			revokeLeaseFlag = true
+			exitStatus = 255
+		}
+		disconnect := auditServerEvent(auditDisconnect)
+		disconnect.ExitStatus, disconnect.Duration = &exitStatus, time.Since(started).Seconds()
+		audit(disconnect)
+		recordLogin(started, exitStatus)
+		if master == nil {
+			renewer.Stop()
+			revokeVaultCredentials()
+		}
+		os.Exit(exitStatus)
 	},
 }
 
-func showVaultLoginPrompt() {
-	var vaultUsername string
-	fmt.Print("Enter your Vault user name: ")
-	fmt.Scanln(&vaultUsername)
-	fmt.Print("Enter your Vault password: ")
-	vaultPassword, _ := gopass.GetPasswd()
-	log.Println("Logging into Vault...")
-	payload := fmt.Sprintf(`{"password": "%s"}`, vaultPassword)
-	body := strings.NewReader(payload)
-	req, err := http.NewRequest("POST", cfg.VaultAddress+"/v1/auth/userpass/login/"+vaultUsername, body)
-	if err != nil {
-		// handle err
+// useNativeSSH reports whether the session is opened with the SSH client built
+// into guttu instead of sshpass, which recording sessions needs
+func useNativeSSH() bool {
+	backend := sshBackendFlag
+	if backend == "" {
+		backend = cfg.SSHBackend
+	}
+	native := false
+	switch backend {
+	case "", "sshpass":
+		if recordSession() {
+			logger.Info("Recording the session needs the native backend, using it")
+			native = true
+		} else if multiplexSession() {
+			logger.Info("Sharing a master connection needs the native backend, using it")
+			native = true
+		}
+	case "native":
+		native = true
+	default:
+		logger.Fatalf("Unknown SSH backend %q, use sshpass or native", backend)
 	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if native && selectedServer.ProxyJump != "" {
+		logger.Fatalf("%s uses proxy_jump, which only the sshpass backend supports", selectedServer.ServerName)
+	}
+	if native {
+		requireNativeSSHAlgorithms()
+	}
+	return native
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		// handle err
+// requireNativeSSHAlgorithms exits when the selected server is configured with
+// ssh algorithms the SSH client built into guttu doesn't implement
+func requireNativeSSHAlgorithms() {
+	algorithms := serverSSHAlgorithms(selectedServer)
+	if unsupported := unsupportedSSHAlgorithms(&algorithms); len(unsupported) > 0 {
+		logger.Fatalf("The native backend doesn't support the ssh algorithms %s of %s, use the sshpass backend", strings.Join(unsupported, ", "), selectedServer.ServerName)
+	}
+}
+
+// findServer looks up a configured server by its name
+func findServer(name string) (GuttuServerStruct, bool) {
+	for _, s := range cfg.Servers {
+		if s.ServerName == name {
+			return s, true
+		}
+	}
+	return GuttuServerStruct{}, false
+}
+
+// selectServerByArg selects the server given on the command line, "-" being the last one used
+func selectServerByArg(arg string) {
+	var ok bool
+	if arg == "-" {
+		selectedServer, ok = lastServer()
+		if !ok {
+			logger.Fatal("No previous login found in history")
+		}
+		return
 	}
-	defer resp.Body.Close()
+	selectedServer, ok = findServer(arg)
+	if !ok {
+		logger.Fatalf("No server named %q in config file", arg)
+	}
+}
 
-	responseBody, readErr := ioutil.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Fatal(readErr)
+// revokeVaultCredentials revokes the OTP lease when asked to and the Vault
+// token unless asked to keep it, so neither outlives the session. A token
+// stored by guttu login is left alone.
+func revokeVaultCredentials() {
+	if (revokeLeaseFlag || cfg.RevokeLease) && vaultSSHOTPLeaseID != "" {
+		if err := revokeLease(vaultSSHOTPLeaseID); err != nil {
+			logger.Warn("Unable to revoke the OTP lease:", err)
+		} else {
+			logger.Info("Revoked the OTP lease")
+			audit(auditServerEvent(auditLeaseRevoked))
+		}
 	}
-	if resp.StatusCode == 200 {
-		VaultAuthloginResponse := VaultAuthLoginResponse{}
-		json.Unmarshal(responseBody, &VaultAuthloginResponse)
-		log.Println("Logged into Vault...")
-		vaultUserToken = VaultAuthloginResponse.Auth.ClientToken
+	switch {
+	case vaultUserToken == "" || vaultTokenStored:
+		// a token from guttu login stays until guttu logout
+	case keepTokenFlag || cfg.KeepToken:
+		if err := storeToken(); err != nil {
+			// a token nothing can find anymore is revoked
+			logger.Warn("Unable to store the Vault token:", err)
+			revokeSessionToken()
+		} else {
+			logger.Info("Kept the Vault token for the next commands, `guttu logout` revokes it")
+		}
+	default:
+		revokeSessionToken()
+	}
+}
+
+// revokeSessionToken revokes the Vault token the session logged in with
+func revokeSessionToken() {
+	if err := revokeToken(); err != nil {
+		logger.Warn("Unable to revoke the Vault token:", err)
 	} else {
-		VaulterrorResponse := VaultErrorResponse{}
-		json.Unmarshal(responseBody, &VaulterrorResponse)
-		log.Fatalf("Error: %s\n", VaulterrorResponse.Errors[0])
+		logger.Info("Revoked the Vault token")
+		audit(AuditEvent{Event: auditTokenRevoked, VaultAddress: vaultAddress()})
+	}
+}
+
+// serverPort returns the SSH port of the server, 22 unless configured
+func serverPort(s GuttuServerStruct) int {
+	if s.Port != 0 {
+		return s.Port
+	}
+	return 22
+}
+
+// recordLogin appends the finished login to the history file
+func recordLogin(started time.Time, exitStatus int) {
+	err := appendHistory(HistoryEntry{
+		ServerName: selectedServer.ServerName,
+		IP:         selectedServer.IP,
+		Time:       started,
+		Duration:   time.Since(started).Seconds(),
+		ExitStatus: exitStatus,
+	})
+	if err != nil {
+		logger.Warn("Unable to record login in history:", err)
 	}
 }
 
@@ -146,76 +264,73 @@ func showServerSelection() {
 	attempt := 1
 	maxAttempt := 3
 
-	table := tablewriter.NewWriter(os.Stdout)
+	requireInteractive("Selecting a server (pass its name instead)")
+	servers := pickerOrder()
+	// the list is part of the prompt, keep it off stdout
+	table := tablewriter.NewWriter(os.Stderr)
 	table.SetHeader([]string{"Number", "Server Name", "IP"})
 	table.SetCaption(true, "Enter the number and hit enter. eg: 1")
-	for key, s := range cfg.Servers {
-		table.Append([]string{strconv.Itoa(key + 1), s.ServerName, s.IP})
+	for key, s := range servers {
+		name := s.ServerName
+		if s.Favourite {
+			name += " *"
+		}
+		table.Append([]string{strconv.Itoa(key + 1), name, s.IP})
 	}
 	table.Render() // Send output
 	// get server number from the prompt
+	var selectedServerNumber int
 	for true {
 		fmt.Scanln(&selectedServerNumber)
 		if attempt == maxAttempt {
-			log.Fatalln("Reached max invalid attempt", maxAttempt)
+			logger.Fatal("Reached max invalid attempt", maxAttempt)
 		}
-		if selectedServerNumber < 1 || selectedServerNumber > len(cfg.Servers) {
+		if selectedServerNumber < 1 || selectedServerNumber > len(servers) {
 			attempt++
-			fmt.Printf("Please enter a valid number between %d and %d!\n", 1, len(cfg.Servers))
+			fmt.Fprintf(os.Stderr, "Please enter a valid number between %d and %d!\n", 1, len(servers))
 		} else {
 			break
 		}
 	}
+	selectedServer = servers[selectedServerNumber-1]
 }
 func generateVaultCredentials() {
-	fmt.Println("You selected", selectedServerNumber)
-	fmt.Println("Generating OTP from vault for", cfg.Servers[selectedServerNumber-1].ServerName, "...")
-
-	payload := fmt.Sprintf(`{"ip": "%s"}`, cfg.Servers[selectedServerNumber-1].IP)
-	body := strings.NewReader(payload)
-
-	req, err := http.NewRequest("POST", cfg.VaultAddress+"/v1/ssh/creds/"+cfg.Servers[selectedServerNumber].VaultRole, body)
-	if err != nil {
-		// handle err
+	if err := requestVaultCredentials(); err != nil {
+		exitOnVaultError(err)
 	}
-	req.Header.Set("X-Vault-Token", vaultUserToken)
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+}
 
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		// handle err
-	}
-	defer resp.Body.Close()
-	responseBody, readErr := ioutil.ReadAll(resp.Body)
-	if readErr != nil {
-		log.Fatal(readErr)
-	}
-	if resp.StatusCode == 200 {
-		otpResponse := VaultSSHOTPResponse{}
-		json.Unmarshal(responseBody, &otpResponse)
-		vaultSSHOTPKey = otpResponse.Data.Key
-		log.Println("Generated OTP for", cfg.Servers[selectedServerNumber-1].ServerName, "...")
+// requestVaultCredentials asks Vault for an OTP for the selected server
+func requestVaultCredentials() error {
+	logger.Info("You selected", selectedServer.ServerName)
+	logger.Info("Generating OTP from vault for", selectedServer.ServerName, "...")
 
-	} else {
-		VaulterrorResponse := VaultErrorResponse{}
-		json.Unmarshal(responseBody, &VaulterrorResponse)
-		log.Fatalf("Error: %s\n", VaulterrorResponse.Errors[0])
+	otpResponse := VaultSSHOTPResponse{}
+	err := vaultRequest("POST", "ssh/creds/"+url.PathEscape(selectedServer.VaultRole), VaultSSHCredsRequest{IP: selectedServer.IP}, &otpResponse)
+	if err != nil {
+		return err
 	}
+	vaultSSHOTPKey = otpResponse.Data.Key
+	vaultSSHOTPLeaseID = otpResponse.LeaseID
+	logger.Info("Generated OTP for", selectedServer.ServerName, "...")
+	e := auditServerEvent(auditCredsIssued)
+	e.VaultAddress = vaultAddress()
+	audit(e)
+	return nil
 }
 
-func loginToServer() {
-
-	sshConfig := &ssh.ClientConfig{
-		User: cfg.Servers[selectedServerNumber-1].LoginUsername,
+// sshClientConfig returns the config logging into the selected server with the OTP
+func sshClientConfig() *ssh.ClientConfig {
+	config := &ssh.ClientConfig{
+		User: selectedServer.LoginUsername,
 		Auth: []ssh.AuthMethod{
 			ssh.RetryableAuthMethod(
 				ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
 					// Just send the password back for all questions
 					answers := make([]string, len(questions))
 					for n := range questions {
-						// fmt.Printf("Got question: %s\n", q)
-						// fmt.Printf("Entering password: %s\n", vaultSSHOTPKey)
-						answers[n] = vaultSSHOTPKey
+						// the ssh package only takes answers as strings, this copy can't be wiped
+						answers[n] = string(vaultSSHOTPKey)
 					}
 					return answers, nil
 				}),
@@ -224,68 +339,238 @@ func loginToServer() {
 		},
 		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
 	}
-	connection, err := ssh.Dial("tcp", cfg.Servers[selectedServerNumber-1].IP+":22", sshConfig)
+	applySSHAlgorithms(config, serverSSHAlgorithms(selectedServer))
+	return config
+}
+
+// dialServer logs into the selected server with the OTP, wiping it once used
+func dialServer() (*ssh.Client, error) {
+	// the OTP is single use, logged in or not it is no longer needed
+	defer vaultSSHOTPKey.Wipe()
+	addr := net.JoinHostPort(selectedServer.IP, strconv.Itoa(serverPort(selectedServer)))
+	conn, err := net.DialTimeout("tcp", addr, sshConnectTimeout)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, err
 	}
+	conn.SetDeadline(time.Now().Add(sshConnectTimeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, sshClientConfig())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// loginToServer opens the session with the SSH client built into guttu and
+// returns its exit status once it ends. Sessions are recorded here when asked to.
+// The session is opened on the given master connection when not nil, else on a
+// new one, through a new master when the server is multiplexed.
+func loginToServer(connection *ssh.Client) (int, error) {
+	if connection == nil {
+		var err error
+		if multiplexSession() {
+			connection, err = startMaster()
+		} else {
+			connection, err = dialServer()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	defer connection.Close()
+	defer startKeepalive(connection, selectedServer.ServerName)()
 
 	session, err := connection.NewSession()
 	if err != nil {
-		log.Fatalln(err)
+		return 0, err
 	}
+	defer session.Close()
 
+	stdinFd, stdoutFd := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	width, height := 80, 24
+	if terminal.IsTerminal(stdoutFd) {
+		if w, h, err := terminal.GetSize(stdoutFd); err == nil {
+			width, height = w, h
+		}
+	}
+	term := os.Getenv("TERM")
+	if term == "" {
+		term = "xterm"
+	}
 	modes := ssh.TerminalModes{
-		ssh.ECHO:          0,     // disable echoing
+		ssh.ECHO:          1,
 		ssh.TTY_OP_ISPEED: 14400, // input speed = 14.4kbaud
 		ssh.TTY_OP_OSPEED: 14400, // output speed = 14.4kbaud
 	}
+	if err := session.RequestPty(term, height, width, modes); err != nil {
+		return 0, fmt.Errorf("request for pseudo terminal failed: %s", err)
+	}
 
-	if err := session.RequestPty("xterm", 80, 40, modes); err != nil {
-		session.Close()
-		log.Fatalf("request for pseudo terminal failed: %s", err)
+	sendSessionEnv(session)
+	if forwardAgent() {
+		if err := startAgentForwarding(connection, session); err != nil {
+			logger.Warn("Unable to forward the SSH agent:", err)
+		} else {
+			auditAgentForwarded()
+		}
 	}
 
-	stdin, err := session.StdinPipe()
-	if err != nil {
-		log.Fatalf("Unable to setup stdin for session: %v", err)
+	var recorder *sessionRecorder
+	if recordSession() {
+		recorder, err = newSessionRecorder(width, height)
+		if err != nil {
+			return 0, fmt.Errorf("unable to start recording the session: %s", err)
+		}
+		defer recorder.Close()
+		logger.Info("Recording the session to", recorder.file.Name())
 	}
-	go io.Copy(stdin, os.Stdin)
 
-	stdout, err := session.StdoutPipe()
-	if err != nil {
-		log.Fatalf("Unable to setup stdout for session: %v", err)
+	session.Stdin = os.Stdin
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	if recorder != nil {
+		session.Stdout = io.MultiWriter(os.Stdout, recorder)
+		session.Stderr = io.MultiWriter(os.Stderr, recorder)
 	}
-	go io.Copy(os.Stdout, stdout)
 
-	stderr, err := session.StderrPipe()
-	if err != nil {
-		log.Fatalf("Unable to setup stderr for session: %v", err)
+	// keys like Ctrl-C go to the remote shell as they are typed
+	if terminal.IsTerminal(stdinFd) {
+		state, err := terminal.MakeRaw(stdinFd)
+		if err != nil {
+			return 0, fmt.Errorf("unable to put the terminal in raw mode: %s", err)
+		}
+		defer terminal.Restore(stdinFd, state)
 	}
-	go io.Copy(os.Stderr, stderr)
+	signal.Ignore(os.Interrupt, syscall.SIGQUIT)
+	defer signal.Reset(os.Interrupt, syscall.SIGQUIT)
 
-	// err = session.Run("1")
-	log.Println("Logged in to", cfg.Servers[selectedServerNumber-1].ServerName, "...")
-	session.Shell()
-	session.Wait()
+	stopResizing := watchTerminalSize(stdoutFd, func(w, h int) {
+		session.WindowChange(h, w)
+		if recorder != nil {
+			recorder.Resize(w, h)
+		}
+	})
+	defer stopResizing()
+
+	if command := remoteCommand(); command != "" {
+		err = session.Start(command)
+	} else {
+		err = session.Shell()
+	}
+	if err != nil {
+		// returned so the terminal is restored before guttu exits
+		return 0, err
+	}
+	err = session.Wait()
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return exitErr.ExitStatus(), nil
+	}
+	if err != nil {
+		// like ssh, the connection dropped without an exit status
+		return 255, nil
+	}
+	return 0, nil
 }
 
-func loginToServerWithSSHPass() {
-	binary, lookErr := exec.LookPath("sshpass")
-	if lookErr != nil {
-		panic(lookErr)
+// loginToServerWithSSHPass runs ssh through the sshpass binary and returns its exit status once the session ends.
+// The OTP is handed to sshpass through an inherited pipe (-d) or the SSHPASS
+// environment variable (-e), never on the command line where every local user
+// could read it from /proc/<pid>/cmdline.
+func loginToServerWithSSHPass(binary string) (int, error) {
+	sshpass := exec.Command(binary)
+	sshpass.Stdin = os.Stdin
+	sshpass.Stdout = os.Stdout
+	sshpass.Stderr = os.Stderr
+
+	var otpWriter *os.File
+	switch cfg.SSHPassSecret {
+	case "env":
+		// only readable by the same user, through /proc/<pid>/environ, but
+		// the environment has to be built from strings that can't be wiped
+		sshpass.Args = append(sshpass.Args, "-e")
+		sshpass.Env = append(os.Environ(), "SSHPASS="+string(vaultSSHOTPKey))
+	case "", "fd":
+		otpReader, w, err := os.Pipe()
+		if err != nil {
+			return 0, err
+		}
+		defer otpReader.Close()
+		otpWriter = w
+		// ExtraFiles[0] is fd 3 in sshpass
+		sshpass.ExtraFiles = []*os.File{otpReader}
+		sshpass.Args = append(sshpass.Args, "-d", "3")
+	default:
+		logger.Fatalf("Unknown sshpass_secret %q, use fd or env", cfg.SSHPassSecret)
 	}
-	args := []string{"sshpass", "-p", vaultSSHOTPKey, "ssh", cfg.Servers[selectedServerNumber-1].LoginUsername + "@" + cfg.Servers[selectedServerNumber-1].IP}
-	env := os.Environ()
-	execErr := syscall.Exec(binary, args, env)
-	if execErr != nil {
-		panic(execErr)
+
+	sshpass.Args = append(sshpass.Args, "ssh")
+	if selectedServer.Port != 0 {
+		sshpass.Args = append(sshpass.Args, "-p", strconv.Itoa(selectedServer.Port))
 	}
+	if selectedServer.ProxyJump != "" {
+		sshpass.Args = append(sshpass.Args, "-J", selectedServer.ProxyJump)
+	}
+	// only when configured, so the ssh_config of the user applies otherwise
+	if cfg.KeepaliveInterval > 0 {
+		// ssh counts in seconds, round up so a sub-second interval doesn't disable it
+		sshpass.Args = append(sshpass.Args, "-o", fmt.Sprintf("ServerAliveInterval=%d", int((cfg.KeepaliveInterval+time.Second-1)/time.Second)))
+	}
+	if cfg.KeepaliveCountMax > 0 {
+		sshpass.Args = append(sshpass.Args, "-o", fmt.Sprintf("ServerAliveCountMax=%d", cfg.KeepaliveCountMax))
+	}
+	sshpass.Args = append(sshpass.Args, sshAlgorithmOptions(serverSSHAlgorithms(selectedServer))...)
+	if forwardAgent() {
+		sshpass.Args = append(sshpass.Args, "-A")
+		auditAgentForwarded()
+	}
+	sshpass.Args = append(sshpass.Args, sshEnvOptions()...)
+	command := remoteCommand()
+	if command != "" {
+		// a pseudo terminal like the login shell gets
+		sshpass.Args = append(sshpass.Args, "-t")
+	}
+	sshpass.Args = append(sshpass.Args, selectedServer.LoginUsername+"@"+selectedServer.IP)
+	if command != "" {
+		sshpass.Args = append(sshpass.Args, command)
+	}
+
+	// Ctrl-C belongs to the remote shell, don't let it kill guttu before the login is recorded
+	signal.Ignore(os.Interrupt, syscall.SIGQUIT)
+	defer signal.Reset(os.Interrupt, syscall.SIGQUIT)
 
+	if err := sshpass.Start(); err != nil {
+		if otpWriter != nil {
+			otpWriter.Close()
+		}
+		return 0, err
+	}
+	if otpWriter != nil {
+		otpWriter.Write(vaultSSHOTPKey)
+		otpWriter.Write([]byte("\n"))
+		otpWriter.Close()
+	}
+	// sshpass has its own copy now, ours is no longer needed
+	vaultSSHOTPKey.Wipe()
+
+	runErr := sshpass.Wait()
+	if exitErr, ok := runErr.(*exec.ExitError); ok {
+		return exitErr.Sys().(syscall.WaitStatus).ExitStatus(), nil
+	}
+	return 0, runErr
 }
 
 func init() {
 	rootCmd.AddCommand(sshCmd)
 
+	sshCmd.Flags().BoolVar(&revokeLeaseFlag, "revoke-lease", false, "revoke the OTP lease when the session ends (revoke_lease in the config file)")
+	sshCmd.Flags().StringVar(&sshBackendFlag, "backend", "", "open the session with sshpass or native, the SSH client built into guttu (ssh_backend in the config file)")
+	sshCmd.Flags().BoolVarP(&forwardAgentFlag, "forward-agent", "A", false, "forward your SSH agent to the server, as ssh -A")
+	sshCmd.Flags().StringVar(&remoteCommandFlag, "command", "", "run this command, such as \"tmux attach\", instead of the login shell (remote_command of the server)")
+	sshCmd.Flags().BoolVar(&multiplexFlag, "mux", false, "share a master connection with the following sessions on the server, needs the native backend (multiplex in the config file)")
+	sshCmd.Flags().BoolVar(&recordFlag, "record", false, "record the session in asciicast format, needs the native backend")
+	sshCmd.Flags().BoolVar(&keepTokenFlag, "keep-token", false, "store the Vault token for the next commands instead of revoking it (keep_token in the config file)")
+
 	// Here you will define your flags and configuration settings.
 
 	// Cobra supports Persistent Flags which will work for this command
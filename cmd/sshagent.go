@@ -0,0 +1,92 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var forwardAgentFlag bool
+
+// forwardAgent reports whether the local SSH agent is forwarded to the selected server
+func forwardAgent() bool {
+	return forwardAgentFlag || selectedServer.ForwardAgent
+}
+
+// startAgentForwarding asks the server to forward the agent connections of
+// the session, as ssh -A does, and proxies them to the agent on SSH_AUTH_SOCK
+func startAgentForwarding(client *ssh.Client, session *ssh.Session) error {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return errors.New("SSH_AUTH_SOCK is not set, there is no agent to forward")
+	}
+	channels := client.HandleChannelOpen("auth-agent@openssh.com")
+	if channels == nil {
+		return errors.New("agent forwarding is already set up")
+	}
+	go func() {
+		for channel := range channels {
+			go proxyAgentChannel(channel, socket)
+		}
+	}()
+
+	ok, err := session.SendRequest("auth-agent-req@openssh.com", true, nil)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New("the server refused agent forwarding")
+	}
+	return nil
+}
+
+// proxyAgentChannel connects an agent channel opened by the server to the local agent
+func proxyAgentChannel(newChannel ssh.NewChannel, socket string) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "no agent")
+		logger.Warn("Unable to reach the SSH agent:", err)
+		return
+	}
+	defer conn.Close()
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(channel, conn)
+		channel.CloseWrite()
+		close(done)
+	}()
+	io.Copy(conn, channel)
+	// the server is done with the agent, stop waiting for it
+	conn.Close()
+	<-done
+}
@@ -0,0 +1,248 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// sshConfigField matches an argument of an ssh_config line, quoted or not
+var sshConfigField = regexp.MustCompile(`"[^"]*"|\S+`)
+
+// sshConfigBlock struct for a Host block of an OpenSSH client config file
+type sshConfigBlock struct {
+	Patterns []string
+	Within   [][]string        // patterns of the Host blocks holding the Include the block comes from
+	Options  map[string]string // lower cased keyword, first value wins
+}
+
+// sshConfigHost struct for the resolved settings of a concrete Host alias
+type sshConfigHost struct {
+	Alias     string
+	HostName  string
+	User      string
+	Port      string
+	ProxyJump string
+}
+
+// parseSSHConfig reads an OpenSSH client config file, following Include
+// directives. Match blocks are skipped as they depend on the connection.
+func parseSSHConfig(file string) ([]sshConfigBlock, error) {
+	var blocks []sshConfigBlock
+	// options before the first Host line apply to every host
+	current := &sshConfigBlock{Patterns: []string{"*"}, Options: map[string]string{}}
+	skipping := false
+	if err := readSSHConfigFile(file, 0, nil, &blocks, &current, &skipping); err != nil {
+		return nil, err
+	}
+	blocks = append(blocks, *current)
+	return blocks, nil
+}
+
+func readSSHConfigFile(file string, depth int, within [][]string, blocks *[]sshConfigBlock, current **sshConfigBlock, skipping *bool) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: Include nested more than %d levels deep", file, maxIncludeDepth)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		keyword, args := splitSSHConfigLine(scanner.Text())
+		switch keyword {
+		case "":
+			continue
+		case "host":
+			*blocks = append(*blocks, **current)
+			*current = &sshConfigBlock{Patterns: args, Within: within, Options: map[string]string{}}
+			*skipping = false
+		case "match":
+			*blocks = append(*blocks, **current)
+			*current = &sshConfigBlock{Options: map[string]string{}}
+			*skipping = true
+		case "include":
+			if *skipping {
+				continue
+			}
+			// the Host lines of the included files only apply within the block
+			// of the Include, which goes on after them as in ssh(1)
+			outer := *current
+			inner := append(append([][]string{}, within...), outer.Patterns)
+			for _, pattern := range args {
+				pattern, _ = homedir.Expand(pattern)
+				if !filepath.IsAbs(pattern) {
+					// relative includes are relative to ~/.ssh, as for ssh(1)
+					home, _ := homedir.Dir()
+					pattern = filepath.Join(home, ".ssh", pattern)
+				}
+				matches, _ := filepath.Glob(pattern)
+				for _, match := range matches {
+					if err := readSSHConfigFile(match, depth+1, inner, blocks, current, skipping); err != nil {
+						return err
+					}
+				}
+			}
+			if *current != outer {
+				*blocks = append(*blocks, **current)
+				*current = &sshConfigBlock{Patterns: outer.Patterns, Within: outer.Within, Options: map[string]string{}}
+				*skipping = false
+			}
+		default:
+			if *skipping || len(args) == 0 {
+				continue
+			}
+			if _, ok := (*current).Options[keyword]; !ok {
+				(*current).Options[keyword] = strings.Join(args, " ")
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// splitSSHConfigLine returns the lower cased keyword and the arguments of a
+// config line, accepting both "Keyword value" and "Keyword=value"
+func splitSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+	i := strings.IndexAny(line, " \t=")
+	if i == -1 {
+		return strings.ToLower(line), nil
+	}
+	keyword := strings.ToLower(line[:i])
+	rest := strings.TrimLeft(line[i:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	for _, field := range sshConfigField.FindAllString(rest, -1) {
+		args = append(args, strings.Trim(field, `"`))
+	}
+	return keyword, args
+}
+
+// sshConfigHosts returns the resolved settings of every Host alias that is
+// not a pattern, applying matching blocks in order with the first value winning
+func sshConfigHosts(blocks []sshConfigBlock) []sshConfigHost {
+	var hosts []sshConfigHost
+	seen := map[string]bool{}
+	for _, b := range blocks {
+		for _, alias := range b.Patterns {
+			if seen[alias] || strings.ContainsAny(alias, "*?!") || !sshConfigBlockMatch(b, alias) {
+				continue
+			}
+			seen[alias] = true
+
+			options := map[string]string{}
+			for _, other := range blocks {
+				if !sshConfigBlockMatch(other, alias) {
+					continue
+				}
+				for k, v := range other.Options {
+					if _, ok := options[k]; !ok {
+						options[k] = v
+					}
+				}
+			}
+			h := sshConfigHost{
+				Alias:     alias,
+				HostName:  strings.Replace(options["hostname"], "%h", alias, -1),
+				User:      options["user"],
+				Port:      options["port"],
+				ProxyJump: options["proxyjump"],
+			}
+			if h.HostName == "" {
+				h.HostName = alias
+			}
+			if strings.EqualFold(h.ProxyJump, "none") {
+				h.ProxyJump = ""
+			}
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// sshConfigBlockMatch reports whether a block applies to the host, within the
+// blocks holding the Include it comes from
+func sshConfigBlockMatch(b sshConfigBlock, host string) bool {
+	for _, patterns := range b.Within {
+		if !sshPatternListMatch(patterns, host) {
+			return false
+		}
+	}
+	return sshPatternListMatch(b.Patterns, host)
+}
+
+// sshPatternListMatch reports whether the host matches a Host pattern list:
+// at least one pattern matches and no negated pattern does
+func sshPatternListMatch(patterns []string, host string) bool {
+	matched := false
+	for _, p := range patterns {
+		if strings.HasPrefix(p, "!") {
+			if sshPatternMatch(p[1:], host) {
+				return false
+			}
+			continue
+		}
+		if sshPatternMatch(p, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// sshPatternMatch matches a single ssh_config pattern where * matches any
+// run of characters and ? exactly one, ignoring case
+func sshPatternMatch(patternText, hostText string) bool {
+	pattern, host := []rune(strings.ToLower(patternText)), []rune(strings.ToLower(hostText))
+	p, h, starP, starH := 0, 0, -1, 0
+	for h < len(host) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			starP, starH = p, h
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == host[h]):
+			p++
+			h++
+		case starP >= 0:
+			// on a mismatch the last * takes one more character
+			starH++
+			p, h = starP+1, starH
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
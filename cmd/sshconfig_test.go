@@ -0,0 +1,92 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSSHConfigIncludeInHostBlock(t *testing.T) {
+	dir := t.TempDir()
+	writeConfig := func(name, contents string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	included := writeConfig("prod.conf", `User deploy
+Host prod-web
+    HostName 10.0.0.1
+Host staging-web
+    HostName 10.0.1.1
+`)
+	config := writeConfig("config", `Host prod-*
+    Include `+included+`
+    Port 2222
+    User admin
+
+Host prod-db
+    HostName 10.0.0.2
+`)
+
+	blocks, err := parseSSHConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sshConfigHost{
+		{Alias: "prod-web", HostName: "10.0.0.1", User: "deploy", Port: "2222"},
+		{Alias: "prod-db", HostName: "10.0.0.2", User: "deploy", Port: "2222"},
+	}
+	if hosts := sshConfigHosts(blocks); !reflect.DeepEqual(hosts, want) {
+		t.Errorf("hosts = %+v, want %+v", hosts, want)
+	}
+}
+
+func TestSSHPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"*", "web", true},
+		{"*", "", true},
+		{"web", "WEB", true},
+		{"web", "web1", false},
+		{"web?", "web1", true},
+		{"web?", "web", false},
+		{"*.example.com", "a.b.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"a*b*c", "aXbYbZc", true},
+		{"a*b*c", "aXbYc.d", false},
+		{"10.0.*.1", "10.0.20.1", true},
+		{"db[1]", "db[1]", true},
+		{"host/*", "host/x/y", true},
+		{"é?", "éa", true},
+	}
+	for _, tt := range tests {
+		if got := sshPatternMatch(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("sshPatternMatch(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
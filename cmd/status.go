@@ -0,0 +1,95 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// StatusResult struct for the session shown by the status command
+type StatusResult struct {
+	VaultAddress     string   `json:"vault_address" yaml:"vault_address"`
+	Agent            bool     `json:"agent" yaml:"agent"`
+	Identity         string   `json:"identity" yaml:"identity"`
+	Policies         []string `json:"policies" yaml:"policies"`
+	IdentityPolicies []string `json:"identity_policies" yaml:"identity_policies"`
+	EntityID         string   `json:"entity_id" yaml:"entity_id"`
+	TTL              int      `json:"ttl_seconds" yaml:"ttl_seconds"`
+	Renewable        bool     `json:"renewable" yaml:"renewable"`
+}
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the Vault session stored by guttu login",
+	Long:  `Show who the token stored by guttu login belongs to, its policies, how long it stays valid and if it can be renewed.`,
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		vaultUserToken = readStoredToken()
+		if vaultUserToken == "" && !agentInUse() {
+			fmt.Fprintln(os.Stderr, "Not logged in, run guttu login")
+			os.Exit(1)
+		}
+		lookup, err := lookupToken()
+		if err != nil {
+			exitOnVaultError(err)
+		}
+
+		result := StatusResult{
+			VaultAddress:     vaultAddress(),
+			Agent:            agentInUse() && vaultUserToken == "",
+			Identity:         lookup.Data.DisplayName,
+			Policies:         lookup.Data.Policies,
+			IdentityPolicies: lookup.Data.IdentityPolicies,
+			EntityID:         lookup.Data.EntityID,
+			TTL:              lookup.Data.TTL,
+			Renewable:        lookup.Data.Renewable,
+		}
+		vaultAddr := result.VaultAddress
+		if result.Agent {
+			result.VaultAddress = agentAddress()
+			vaultAddr = "Vault Agent at " + agentAddress()
+		}
+		ttl := "never expires"
+		if lookup.Data.TTL > 0 {
+			ttl = (time.Duration(lookup.Data.TTL) * time.Second).String()
+		}
+		printResult(result, nil, [][]string{
+			{"Vault Address", vaultAddr},
+			{"Identity", result.Identity},
+			{"Policies", strings.Join(result.Policies, ", ")},
+			{"Identity Policies", strings.Join(result.IdentityPolicies, ", ")},
+			{"Entity ID", result.EntityID},
+			{"TTL Remaining", ttl},
+			{"Renewable", strconv.FormatBool(result.Renewable)},
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
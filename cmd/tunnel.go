@@ -0,0 +1,303 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	tunnelBackoffBase = time.Second
+	tunnelBackoffMax  = time.Minute
+)
+
+var tunnelForwards []string
+
+// localForward is a port forwarded with guttu tunnel -L
+type localForward struct {
+	spec   string
+	listen string
+	remote string
+}
+
+// tunnelCmd represents the tunnel command
+var tunnelCmd = &cobra.Command{
+	Use:   "tunnel <server name> -L [bind_address:]port:host:hostport",
+	Short: "Forward local ports through a server, reconnecting when the connection drops",
+	Long: `Forward local ports to hosts reachable from a server, like ssh -N -L, until
+interrupted. The connection is checked with keepalives and when it drops guttu
+logs in again with a fresh OTP, waiting longer after each failed attempt. The
+local ports stay open meanwhile.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(tunnelForwards) == 0 {
+			logger.Fatal("Error: give the ports to forward with -L")
+		}
+		forwards := make([]localForward, len(tunnelForwards))
+		for i, spec := range tunnelForwards {
+			f, err := parseLocalForward(spec)
+			if err != nil {
+				logger.Fatal("Error:", err)
+			}
+			forwards[i] = f
+		}
+		selectServerByArg(args[0])
+		if selectedServer.ProxyJump != "" {
+			logger.Fatalf("%s uses proxy_jump, which guttu tunnel doesn't support", selectedServer.ServerName)
+		}
+		// tunnels always use the native client
+		requireNativeSSHAlgorithms()
+
+		t := &tunnel{}
+		for _, f := range forwards {
+			listener, err := net.Listen("tcp", f.listen)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			go t.serve(listener, f)
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			t.close()
+			t.stopRenewer()
+			revokeVaultCredentials()
+			os.Exit(0)
+		}()
+		t.run(forwards)
+	},
+}
+
+// parseLocalForward parses a -L argument, binding to localhost unless told otherwise
+func parseLocalForward(spec string) (localForward, error) {
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 3:
+		parts = append([]string{"localhost"}, parts...)
+	case 4:
+	default:
+		return localForward{}, fmt.Errorf("%q is not [bind_address:]port:host:hostport", spec)
+	}
+	return localForward{
+		spec:   spec,
+		listen: net.JoinHostPort(parts[0], parts[1]),
+		remote: net.JoinHostPort(parts[2], parts[3]),
+	}, nil
+}
+
+// tunnel holds the connection the forwarded ports go through, nil while
+// reconnecting, and the renewer of the Vault token it logs in with
+type tunnel struct {
+	mu      sync.Mutex
+	client  *ssh.Client
+	renewer *tokenRenewer
+	closed  bool
+	// loggedIn is only used by run
+	loggedIn bool
+}
+
+// run logs into the selected server and logs in again each time the connection drops
+func (t *tunnel) run(forwards []localForward) {
+	reauthenticated := false
+	for attempt := 1; ; {
+		// a master connection to the server needs neither Vault nor a new OTP
+		var client *ssh.Client
+		if multiplexSession() {
+			client = dialMaster(selectedServer.ServerName)
+		}
+		backend := "mux"
+		var err error
+		if client != nil {
+			logger.Info("Reusing the master connection to", selectedServer.ServerName)
+		} else {
+			backend = "tunnel"
+			t.login()
+			err = requestVaultCredentials()
+		}
+		switch {
+		case client != nil:
+		case err == nil:
+			reauthenticated = false
+			client, err = dialServer()
+		case unavailableVaultError(err):
+			// retried like the server, a long lived tunnel outlives both
+			e := auditServerEvent(auditError)
+			e.VaultAddress, e.Error = vaultAddress(), err.Error()
+			audit(e)
+		case tokenRejected(err) && !reauthenticated:
+			// the token expired or was revoked, such as once it reached its max TTL
+			logger.Warn("Vault rejected the token, logging in again:", err)
+			t.reauthenticate()
+			reauthenticated = true
+			continue
+		default:
+			t.close()
+			t.stopRenewer()
+			exitOnVaultError(err)
+		}
+		if err != nil {
+			wait := backoff(attempt, tunnelBackoffBase, tunnelBackoffMax)
+			logger.Warnf("Unable to log into %s: %s, retrying in %s", selectedServer.ServerName, err, wait.Round(time.Millisecond))
+			time.Sleep(wait)
+			attempt++
+			continue
+		}
+		attempt = 1
+		if !t.setClient(client) {
+			return
+		}
+
+		started := time.Now()
+		connect := auditServerEvent(auditConnect)
+		connect.Backend = backend
+		audit(connect)
+		for _, f := range forwards {
+			e := auditServerEvent(auditForward)
+			e.Forward = "local " + f.spec
+			audit(e)
+			logger.Info("Forwarding", f.listen, "to", f.remote, "through", selectedServer.ServerName)
+		}
+
+		stopKeepalive := startKeepalive(client, selectedServer.ServerName)
+		client.Wait()
+		stopKeepalive()
+		t.setClient(nil)
+		disconnect := auditServerEvent(auditDisconnect)
+		disconnect.Duration = time.Since(started).Seconds()
+		audit(disconnect)
+		logger.Warn("Lost the connection to", selectedServer.ServerName+", reconnecting")
+	}
+}
+
+// login logs into Vault and renews the token, the first time the tunnel needs it
+func (t *tunnel) login() {
+	if t.loggedIn {
+		return
+	}
+	ensureVaultToken()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.renewer = startTokenRenewer()
+	t.loggedIn = true
+}
+
+// reauthenticate logs into Vault again and renews the new token
+func (t *tunnel) reauthenticate() {
+	t.stopRenewer()
+	vaultUserToken, vaultTokenStored, vaultTokenSource = "", false, ""
+	ensureVaultToken()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.renewer = startTokenRenewer()
+}
+
+// stopRenewer stops renewing the Vault token
+func (t *tunnel) stopRenewer() {
+	t.mu.Lock()
+	renewer := t.renewer
+	t.renewer = nil
+	t.mu.Unlock()
+	renewer.Stop()
+}
+
+// setClient sets the connection to forward through, false once the tunnel is closed
+func (t *tunnel) setClient(client *ssh.Client) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		if client != nil {
+			client.Close()
+		}
+		return false
+	}
+	t.client = client
+	return true
+}
+
+// close closes the connection and keeps the tunnel from reconnecting
+func (t *tunnel) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closed = true
+	if t.client != nil {
+		t.client.Close()
+	}
+}
+
+// serve forwards the connections accepted on a local port
+func (t *tunnel) serve(listener net.Listener, f localForward) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.forward(conn, f)
+	}
+}
+
+// forward proxies a local connection to the remote end of the forward
+func (t *tunnel) forward(conn net.Conn, f localForward) {
+	defer conn.Close()
+	t.mu.Lock()
+	client := t.client
+	t.mu.Unlock()
+	if client == nil {
+		logger.Warn("Not connected to", selectedServer.ServerName+", dropping a connection to", f.remote)
+		return
+	}
+	remote, err := client.Dial("tcp", f.remote)
+	if err != nil {
+		logger.Warnf("Unable to forward a connection to %s: %s", f.remote, err)
+		return
+	}
+	defer remote.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(remote, conn)
+		remote.Close()
+		close(done)
+	}()
+	io.Copy(conn, remote)
+	conn.Close()
+	<-done
+}
+
+func init() {
+	rootCmd.AddCommand(tunnelCmd)
+
+	tunnelCmd.Flags().StringArrayVarP(&tunnelForwards, "local", "L", nil, "forward [bind_address:]port on this host to host:hostport from the server, repeat for more")
+	tunnelCmd.Flags().BoolVar(&revokeLeaseFlag, "revoke-lease", false, "revoke the last OTP lease when the tunnel is closed (revoke_lease in the config file)")
+	tunnelCmd.Flags().BoolVar(&keepTokenFlag, "keep-token", false, "store the Vault token for the next commands instead of revoking it (keep_token in the config file)")
+}
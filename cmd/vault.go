@@ -0,0 +1,269 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultErrorResponse struct for error response from vault API
+type VaultErrorResponse struct {
+	StatusCode int    `json:"-"`
+	Path       string `json:"-"`
+	Errors     []string
+}
+
+func (e *VaultErrorResponse) Error() string {
+	if len(e.Errors) == 0 {
+		return fmt.Sprintf("Vault responded with status %d", e.StatusCode)
+	}
+	return strings.Join(e.Errors, ", ")
+}
+
+// VaultAuthLoginResponse struct for success response from vault API after logging in
+type VaultAuthLoginResponse struct {
+	RequestID     string      `json:"request_id"`
+	LeaseID       string      `json:"lease_id"`
+	Renewable     bool        `json:"renewable"`
+	LeaseDuration int         `json:"lease_duration"`
+	Data          interface{} `json:"data"`
+	WrapInfo      interface{} `json:"wrap_info"`
+	Warnings      interface{} `json:"warnings"`
+	Auth          struct {
+		ClientToken   string   `json:"client_token"`
+		Accessor      string   `json:"accessor"`
+		Policies      []string `json:"policies"`
+		TokenPolicies []string `json:"token_policies"`
+		Metadata      struct {
+			Username string `json:"username"`
+		} `json:"metadata"`
+		LeaseDuration  int                  `json:"lease_duration"`
+		Renewable      bool                 `json:"renewable"`
+		EntityID       string               `json:"entity_id"`
+		MFARequirement *VaultMFARequirement `json:"mfa_requirement"`
+	} `json:"auth"`
+}
+
+// VaultSSHOTPResponse struct for valid otp response
+type VaultSSHOTPResponse struct {
+	LeaseID       string `json:"lease_id"`
+	Renewable     bool   `json:"renewable"`
+	LeaseDuration int    `json:"lease_duration"`
+	Data          struct {
+		IP       string `json:"ip"`
+		Key      Secret `json:"key"`
+		KeyType  string `json:"key_type"`
+		Port     int    `json:"port"`
+		Username string `json:"username"`
+	} `json:"data"`
+	Warnings interface{} `json:"warnings"`
+	Auth     interface{} `json:"auth"`
+}
+
+// VaultTokenLookupResponse struct for the response of a token lookup
+type VaultTokenLookupResponse struct {
+	Data struct {
+		Accessor         string            `json:"accessor"`
+		DisplayName      string            `json:"display_name"`
+		EntityID         string            `json:"entity_id"`
+		ExpireTime       string            `json:"expire_time"`
+		Policies         []string          `json:"policies"`
+		IdentityPolicies []string          `json:"identity_policies"`
+		Meta             map[string]string `json:"meta"`
+		Renewable        bool              `json:"renewable"`
+		TTL              int               `json:"ttl"`
+	} `json:"data"`
+}
+
+// VaultPasswordLoginRequest struct for the payload of a user name and password login
+type VaultPasswordLoginRequest struct {
+	Password Secret `json:"password"`
+}
+
+// VaultSSHCredsRequest struct for the payload of an OTP request
+type VaultSSHCredsRequest struct {
+	IP string `json:"ip"`
+}
+
+// VaultLeaseRevokeRequest struct for the payload of a lease revocation
+type VaultLeaseRevokeRequest struct {
+	LeaseID string `json:"lease_id"`
+}
+
+// revokeLease revokes a lease, such as the one of a generated OTP, before it expires
+func revokeLease(leaseID string) error {
+	return vaultRequest("PUT", "sys/leases/revoke", VaultLeaseRevokeRequest{LeaseID: leaseID}, nil)
+}
+
+// lookupToken returns the details of the Vault token in use, failing when it is no longer valid
+func lookupToken() (VaultTokenLookupResponse, error) {
+	lookup := VaultTokenLookupResponse{}
+	err := vaultRequest("GET", "auth/token/lookup-self", nil, &lookup)
+	return lookup, err
+}
+
+// revokeToken revokes the Vault token in use along with its child tokens and leases
+func revokeToken() error {
+	err := vaultRequest("POST", "auth/token/revoke-self", nil, nil)
+	if err == nil {
+		vaultUserToken = ""
+	}
+	return err
+}
+
+// vaultRequest sends a request to the Vault API. The payload, when not nil,
+// is sent as JSON and a successful response is decoded into out, when not nil.
+// Vault errors are returned as *VaultErrorResponse.
+func vaultRequest(method, path string, payload interface{}, out interface{}) error {
+	return vaultRequestWithToken(vaultUserToken, method, path, payload, out)
+}
+
+// vaultRequestWithToken sends a request to the Vault API with the given token instead of the one in use.
+// Connection errors and responses of a busy, standby or sealed Vault are retried
+// on the next address, then again on all of them with a backoff.
+func vaultRequestWithToken(token, method, path string, payload interface{}, out interface{}) error {
+	var encoded []byte
+	if payload != nil {
+		var err error
+		encoded, err = json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		// the payload may hold a password
+		defer wipeBytes(encoded)
+	}
+
+	addrs := vaultAddresses()
+	if len(addrs) == 0 {
+		return errors.New("no Vault address configured")
+	}
+	for attempt := 1; ; attempt++ {
+		var err error
+		// start with the address that answered last
+		first := currentVaultAddressIndex()
+		for i := 0; i < len(addrs); i++ {
+			index := (first + i) % len(addrs)
+			err = vaultSend(addrs[index], token, method, path, encoded, out)
+			if !retryableVaultRequest(method, path, err) {
+				if !vaultNotServed(err) {
+					setVaultAddressIndex(index)
+				}
+				return err
+			}
+			if len(addrs) > 1 {
+				logger.Warnf("Vault at %s failed: %s", addrs[index], err)
+			}
+		}
+		if attempt >= vaultMaxAttempts() {
+			return err
+		}
+		wait := vaultBackoff(attempt)
+		logger.Warnf("Vault request failed: %s, retrying in %s", err, wait.Round(time.Millisecond))
+		time.Sleep(wait)
+	}
+}
+
+// vaultAddressIndex is the index in vaultAddresses of the address that answered
+// last, the token renewer sends requests alongside the session
+var (
+	vaultAddressIndex   int
+	vaultAddressIndexMu sync.Mutex
+)
+
+func currentVaultAddressIndex() int {
+	vaultAddressIndexMu.Lock()
+	defer vaultAddressIndexMu.Unlock()
+	return vaultAddressIndex
+}
+
+func setVaultAddressIndex(index int) {
+	vaultAddressIndexMu.Lock()
+	defer vaultAddressIndexMu.Unlock()
+	vaultAddressIndex = index
+}
+
+// vaultAddress returns the Vault address requests are sent to first
+func vaultAddress() string {
+	addrs := vaultAddresses()
+	if len(addrs) == 0 {
+		return ""
+	}
+	return addrs[currentVaultAddressIndex()%len(addrs)]
+}
+
+// vaultSend sends a single request to the Vault API at addr
+func vaultSend(addr, token, method, path string, payload []byte, out interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, addr+"/v1/"+path, body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	if debugHTTP {
+		dumpHTTPRequest(req, payload)
+	}
+	started := time.Now()
+	resp, err := vaultHTTPClient().Do(req)
+	if err != nil {
+		logger.Tracef("%s %s: %s", method, redactURL(req.URL), err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	// the response may hold a token or an OTP
+	defer wipeBytes(responseBody)
+	logger.Tracef("%s %s: %s in %s", method, redactURL(req.URL), resp.Status, time.Since(started).Round(time.Millisecond))
+	if debugHTTP {
+		dumpHTTPResponse(resp, responseBody)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		vaultErr := &VaultErrorResponse{StatusCode: resp.StatusCode, Path: path}
+		json.Unmarshal(responseBody, vaultErr)
+		return vaultErr
+	}
+	if out == nil || len(responseBody) == 0 {
+		return nil
+	}
+	return json.Unmarshal(responseBody, out)
+}
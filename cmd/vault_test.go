@@ -0,0 +1,302 @@
+// Copyright © 2018 Pratheek Hegde <ptk609@gmail.com>
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// vaultCall is a request received by the fake Vault
+type vaultCall struct {
+	Method      string
+	URI         string
+	ContentType string
+	Body        string
+}
+
+// vaultReply is an answer of the fake Vault
+type vaultReply struct {
+	Status   int
+	Response string
+}
+
+// newFakeVault starts a Vault answering requests with the replies in turn, the
+// last one repeating, and returns its address and the requests it received
+func newFakeVault(t *testing.T, replies ...vaultReply) (string, *[]vaultCall) {
+	var mu sync.Mutex
+	calls := &[]vaultCall{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		*calls = append(*calls, vaultCall{r.Method, r.RequestURI, r.Header.Get("Content-Type"), string(body)})
+		reply := replies[len(replies)-1]
+		if n := len(*calls); n <= len(replies) {
+			reply = replies[n-1]
+		}
+		mu.Unlock()
+		w.WriteHeader(reply.Status)
+		w.Write([]byte(reply.Response))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, calls
+}
+
+// fakeVault points guttu at a Vault answering every request with status and
+// response, and returns the requests it received. The config is restored
+// once the test ends.
+func fakeVault(t *testing.T, status int, response string) *[]vaultCall {
+	return fakeVaultReplies(t, vaultReply{status, response})
+}
+
+// fakeVaultReplies points guttu at a Vault answering requests with the replies in turn
+func fakeVaultReplies(t *testing.T, replies ...vaultReply) *[]vaultCall {
+	addr, calls := newFakeVault(t, replies...)
+	savedCfg, savedToken, savedServer := cfg, vaultUserToken, selectedServer
+	t.Cleanup(func() {
+		cfg, vaultUserToken, selectedServer = savedCfg, savedToken, savedServer
+		vaultSSHOTPKey, vaultSSHOTPLeaseID = nil, ""
+		setVaultAddressIndex(0)
+	})
+	t.Setenv("VAULT_AGENT_ADDR", "")
+	cfg = GuttuConfigStruct{VaultAddress: addr, AuditLog: "off", VaultMaxAttempts: 1}
+	vaultUserToken = ""
+	return calls
+}
+
+func TestPasswordLoginBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		body     string
+	}{
+		{"plain", "hunter2", `{"password":"hunter2"}`},
+		{"quotes", `pa"ss"`, `{"password":"pa\"ss\""}`},
+		{"backslashes", `c:\new\`, `{"password":"c:\\new\\"}`},
+		{"newlines", "line1\nline2\r\n", `{"password":"line1\u000aline2\u000d\u000a"}`},
+		{"non-ASCII", "pässwörd ✓ 密码", `{"password":"pässwörd ✓ 密码"}`},
+		{"injection", `x", "policies": ["root"]}`, `{"password":"x\", \"policies\": [\"root\"]}"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeVault(t, 200, `{"auth":{"client_token":"s.token"}}`)
+			response, err := passwordLogin("userpass", "jane", Secret(tt.password))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Auth.ClientToken != "s.token" {
+				t.Errorf("client token = %q, want s.token", response.Auth.ClientToken)
+			}
+			if len(*calls) != 1 {
+				t.Fatalf("got %d requests, want 1", len(*calls))
+			}
+			call := (*calls)[0]
+			if call.Body != tt.body {
+				t.Errorf("body = %s, want %s", call.Body, tt.body)
+			}
+			if call.ContentType != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", call.ContentType)
+			}
+			var decoded VaultPasswordLoginRequest
+			if err := json.Unmarshal([]byte(call.Body), &decoded); err != nil {
+				t.Fatalf("body is not valid JSON: %s", err)
+			}
+			if string(decoded.Password) != tt.password {
+				t.Errorf("decoded password = %q, want %q", decoded.Password, tt.password)
+			}
+		})
+	}
+}
+
+func TestPasswordLoginUsernamePath(t *testing.T) {
+	tests := []struct {
+		username string
+		uri      string
+	}{
+		{"jane", "/v1/auth/ldap/login/jane"},
+		{"corp/jane", "/v1/auth/ldap/login/corp%2Fjane"},
+		{"../../sys/seal", "/v1/auth/ldap/login/..%2F..%2Fsys%2Fseal"},
+		{"jane doe?x=1", "/v1/auth/ldap/login/jane%20doe%3Fx=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			calls := fakeVault(t, 200, `{"auth":{"client_token":"s.token"}}`)
+			if _, err := passwordLogin("ldap", tt.username, Secret("pw")); err != nil {
+				t.Fatal(err)
+			}
+			if len(*calls) != 1 {
+				t.Fatalf("got %d requests, want 1", len(*calls))
+			}
+			if call := (*calls)[0]; call.Method != "POST" || call.URI != tt.uri {
+				t.Errorf("request = %s %s, want POST %s", call.Method, call.URI, tt.uri)
+			}
+		})
+	}
+}
+
+func TestGenerateVaultCredentialsBody(t *testing.T) {
+	tests := []struct {
+		ip   string
+		body string
+	}{
+		{"10.0.0.5", `{"ip":"10.0.0.5"}`},
+		{"2001:db8::1", `{"ip":"2001:db8::1"}`},
+		{"fe80::1ff:fe23:4567:890a", `{"ip":"fe80::1ff:fe23:4567:890a"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			calls := fakeVault(t, 200, `{"lease_id":"ssh/creds/web/abc","data":{"key":"otp-1234","ip":"`+tt.ip+`"}}`)
+			selectedServer = GuttuServerStruct{ServerName: "web", IP: tt.ip, LoginUsername: "ubuntu", VaultRole: "web/role"}
+			vaultUserToken = "s.token"
+			generateVaultCredentials()
+
+			if len(*calls) != 1 {
+				t.Fatalf("got %d requests, want 1", len(*calls))
+			}
+			call := (*calls)[0]
+			if call.URI != "/v1/ssh/creds/web%2Frole" {
+				t.Errorf("URI = %s, want /v1/ssh/creds/web%%2Frole", call.URI)
+			}
+			if call.Body != tt.body {
+				t.Errorf("body = %s, want %s", call.Body, tt.body)
+			}
+			if call.ContentType != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", call.ContentType)
+			}
+			if string(vaultSSHOTPKey) != "otp-1234" || vaultSSHOTPLeaseID != "ssh/creds/web/abc" {
+				t.Errorf("got OTP %q and lease %q", vaultSSHOTPKey, vaultSSHOTPLeaseID)
+			}
+		})
+	}
+}
+
+func TestVaultRequestWithoutPayload(t *testing.T) {
+	calls := fakeVault(t, 200, `{"data":{"display_name":"userpass-jane"}}`)
+	vaultUserToken = "s.token"
+	lookup, err := lookupToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lookup.Data.DisplayName != "userpass-jane" {
+		t.Errorf("display name = %q", lookup.Data.DisplayName)
+	}
+	if call := (*calls)[0]; call.Body != "" || call.ContentType != "" {
+		t.Errorf("a GET sent body %q with Content-Type %q", call.Body, call.ContentType)
+	}
+}
+
+func TestVaultErrorResponse(t *testing.T) {
+	fakeVault(t, 400, `{"errors":["invalid username or password"]}`)
+	_, err := passwordLogin("userpass", "jane", Secret("wrong"))
+	vaultErr, ok := err.(*VaultErrorResponse)
+	if !ok {
+		t.Fatalf("err = %v, want a *VaultErrorResponse", err)
+	}
+	if vaultErr.StatusCode != 400 || vaultErr.Error() != "invalid username or password" {
+		t.Errorf("err = %d %q", vaultErr.StatusCode, vaultErr.Error())
+	}
+	if code := vaultExitCode(err); code != exitAuthFailed {
+		t.Errorf("exit code = %d, want %d", code, exitAuthFailed)
+	}
+}
+
+func TestVaultRetries(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		status int
+		calls  int
+	}{
+		{"GET on a failing Vault", "GET", "auth/token/lookup-self", 500, 2},
+		{"POST on a failing Vault", "POST", "ssh/creds/otp", 500, 1},
+		{"POST on a sealed Vault", "POST", "ssh/creds/otp", 503, 2},
+		{"POST on a standby", "POST", "ssh/creds/otp", 429, 2},
+		{"POST on a Vault not ready", "POST", "ssh/creds/otp", 412, 2},
+		{"login on a failing Vault", "POST", "auth/userpass/login/jane", 500, 2},
+		{"unwrap on a failing Vault", "POST", "sys/wrapping/unwrap", 500, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeVault(t, tt.status, `{"errors":["unavailable"]}`)
+			cfg.VaultMaxAttempts = 2
+			err := vaultRequestWithToken("s.token", tt.method, tt.path, nil, nil)
+			if code := vaultExitCode(err); code != exitVaultUnavailable {
+				t.Errorf("exit code = %d, want %d", code, exitVaultUnavailable)
+			}
+			if len(*calls) != tt.calls {
+				t.Errorf("Vault received %d requests, want %d", len(*calls), tt.calls)
+			}
+		})
+	}
+}
+
+func TestLoginRetriedOnSealedVault(t *testing.T) {
+	calls := fakeVaultReplies(t,
+		vaultReply{503, `{"errors":["Vault is sealed"]}`},
+		vaultReply{200, `{"auth":{"client_token":"s.token"}}`})
+	cfg.VaultMaxAttempts = 2
+	response, err := passwordLogin("userpass", "jane", Secret("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Auth.ClientToken != "s.token" {
+		t.Errorf("token = %q", response.Auth.ClientToken)
+	}
+	if len(*calls) != 2 {
+		t.Errorf("Vault received %d requests, want 2", len(*calls))
+	}
+}
+
+func TestLoginFailsOver(t *testing.T) {
+	sealed := fakeVault(t, 503, `{"errors":["Vault is sealed"]}`)
+	second, calls := newFakeVault(t, vaultReply{200, `{"auth":{"client_token":"s.token"}}`})
+	cfg.VaultAddresses = []string{second}
+	response, err := passwordLogin("userpass", "jane", Secret("hunter2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Auth.ClientToken != "s.token" {
+		t.Errorf("token = %q", response.Auth.ClientToken)
+	}
+	if len(*sealed) != 1 || len(*calls) != 1 {
+		t.Errorf("requests = %d to the sealed Vault and %d to the second one, want 1 each", len(*sealed), len(*calls))
+	}
+	if addr := vaultAddress(); addr != second {
+		t.Errorf("Vault address in use = %s, want %s", addr, second)
+	}
+}
+
+func TestRequestNotSent(t *testing.T) {
+	fakeVault(t, 200, `{}`)
+	cfg.VaultAddress = "http://127.0.0.1:1"
+	err := vaultRequestWithToken("s.token", "POST", "ssh/creds/otp", nil, nil)
+	if !requestNotSent(err) {
+		t.Errorf("a refused connection wasn't reported as unsent: %v", err)
+	}
+	if requestNotSent(&VaultErrorResponse{StatusCode: 503}) {
+		t.Error("a Vault response was reported as unsent")
+	}
+}